@@ -57,6 +57,14 @@ deploy() {
     echo 'Installing Pipeline'
 
     kubectl apply -f am/namespace.yaml
+
+    echo 'Copying Elastic credentials to the pipeline namespace'
+
+    kubectl get secret elastic-cluster-es-http-certs-public -n elastic -o go-template='{{index .data "ca.crt" | base64decode}}' > ca.crt
+    kubectl get secret elastic-cluster-es-elastic-user -n elastic -o go-template='{{.data.elastic | base64decode}}' > password
+    kubectl create secret generic elastic-credentials -n am --from-file=ca.crt --from-file=password --dry-run -o yaml | kubectl apply -f -
+    rm ca.crt password
+
     kubectl apply -f am/feeder-api.yaml
     kubectl apply -f am/indexer.yaml
     kubectl apply -f am/web-api.yaml
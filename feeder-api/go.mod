@@ -5,9 +5,14 @@ go 1.13
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/Shopify/sarama v1.23.1
+	github.com/golang/protobuf v1.3.2
 	github.com/gorilla/mux v1.7.3
 	github.com/mateuszdyminski/am-pipeline/models v0.0.0-20190919094627-bec8d1e2eafe
 	github.com/prometheus/client_golang v1.1.0
 	github.com/rs/zerolog v1.15.0
 	github.com/sirupsen/logrus v1.4.2
+	google.golang.org/grpc v1.24.0
+	gopkg.in/jcmturner/goidentity.v3 v3.0.0 // indirect
 )
+
+replace github.com/mateuszdyminski/am-pipeline/models => ../models
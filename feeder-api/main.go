@@ -7,6 +7,7 @@ import (
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/pumper"
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/server"
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/signals"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -37,5 +38,12 @@ func main() {
 		log.Fatal("can't create pumper", err)
 	}
 
-	server.ListenAndServe(pumper, cfg, ctx)
+	checker := checks.New(checks.DefaultTimeout, checks.DefaultCacheTTL)
+	checker.Register("kafka", pumper.CheckKafka)
+
+	server.ListenAndServe(pumper, checker, cfg, ctx)
+
+	if err := pumper.Close(); err != nil {
+		log.Error("can't close pumper", err)
+	}
 }
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"github.com/mateuszdyminski/am-pipeline/models"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// ToModel converts protobuf User into models.User.
+func (u *User) ToModel() models.User {
+	user := models.User{
+		Pnum:     u.GetId(),
+		Email:    stringValue(u.GetEmail()),
+		Dob:      stringValue(u.GetDob()),
+		Weight:   intValue(u.GetWeight()),
+		Height:   intValue(u.GetHeight()),
+		Nickname: stringValue(u.GetNickname()),
+		Country:  int(u.GetCountry()),
+		City:     stringValue(u.GetCity()),
+		Caption:  stringValue(u.GetCaption()),
+		Gender:   intValue(u.GetGender()),
+	}
+
+	if loc := u.GetLocation(); loc != nil {
+		user.Location = &models.Location{Longitude: loc.GetLon(), Latitude: loc.GetLat()}
+	}
+
+	return user
+}
+
+// FromModel converts models.User into protobuf User.
+func FromModel(u models.User) *User {
+	user := &User{
+		Id:       u.Pnum,
+		Email:    wrapString(u.Email),
+		Dob:      wrapString(u.Dob),
+		Weight:   wrapInt(u.Weight),
+		Height:   wrapInt(u.Height),
+		Nickname: wrapString(u.Nickname),
+		Country:  int32(u.Country),
+		City:     wrapString(u.City),
+		Caption:  wrapString(u.Caption),
+		Gender:   wrapInt(u.Gender),
+	}
+
+	if u.Location != nil {
+		user.Location = &Location{Lon: u.Location.Longitude, Lat: u.Location.Latitude}
+	}
+
+	return user
+}
+
+func stringValue(v *wrappers.StringValue) *string {
+	if v == nil {
+		return nil
+	}
+
+	s := v.GetValue()
+	return &s
+}
+
+func intValue(v *wrappers.Int32Value) *int {
+	if v == nil {
+		return nil
+	}
+
+	i := int(v.GetValue())
+	return &i
+}
+
+func wrapString(s *string) *wrappers.StringValue {
+	if s == nil {
+		return nil
+	}
+
+	return &wrappers.StringValue{Value: *s}
+}
+
+func wrapInt(i *int) *wrappers.Int32Value {
+	if i == nil {
+		return nil
+	}
+
+	return &wrappers.Int32Value{Value: int32(*i)}
+}
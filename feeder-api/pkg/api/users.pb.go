@@ -0,0 +1,516 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: pkg/api/users.proto
+
+package api
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// User mirrors models.User. Wrapped fields are optional.
+type User struct {
+	Id                   int64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Email                *wrappers.StringValue `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	Dob                  *wrappers.StringValue `protobuf:"bytes,3,opt,name=dob,proto3" json:"dob,omitempty"`
+	Weight               *wrappers.Int32Value  `protobuf:"bytes,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	Height               *wrappers.Int32Value  `protobuf:"bytes,5,opt,name=height,proto3" json:"height,omitempty"`
+	Nickname             *wrappers.StringValue `protobuf:"bytes,6,opt,name=nickname,proto3" json:"nickname,omitempty"`
+	Country              int32                 `protobuf:"varint,7,opt,name=country,proto3" json:"country,omitempty"`
+	City                 *wrappers.StringValue `protobuf:"bytes,8,opt,name=city,proto3" json:"city,omitempty"`
+	Caption              *wrappers.StringValue `protobuf:"bytes,9,opt,name=caption,proto3" json:"caption,omitempty"`
+	Location             *Location             `protobuf:"bytes,10,opt,name=location,proto3" json:"location,omitempty"`
+	Gender               *wrappers.Int32Value  `protobuf:"bytes,11,opt,name=gender,proto3" json:"gender,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *User) Reset()         { *m = User{} }
+func (m *User) String() string { return proto.CompactTextString(m) }
+func (*User) ProtoMessage()    {}
+func (*User) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d5b8ea28545eaa7b, []int{0}
+}
+
+func (m *User) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_User.Unmarshal(m, b)
+}
+func (m *User) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_User.Marshal(b, m, deterministic)
+}
+func (m *User) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_User.Merge(m, src)
+}
+func (m *User) XXX_Size() int {
+	return xxx_messageInfo_User.Size(m)
+}
+func (m *User) XXX_DiscardUnknown() {
+	xxx_messageInfo_User.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_User proto.InternalMessageInfo
+
+func (m *User) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *User) GetEmail() *wrappers.StringValue {
+	if m != nil {
+		return m.Email
+	}
+	return nil
+}
+
+func (m *User) GetDob() *wrappers.StringValue {
+	if m != nil {
+		return m.Dob
+	}
+	return nil
+}
+
+func (m *User) GetWeight() *wrappers.Int32Value {
+	if m != nil {
+		return m.Weight
+	}
+	return nil
+}
+
+func (m *User) GetHeight() *wrappers.Int32Value {
+	if m != nil {
+		return m.Height
+	}
+	return nil
+}
+
+func (m *User) GetNickname() *wrappers.StringValue {
+	if m != nil {
+		return m.Nickname
+	}
+	return nil
+}
+
+func (m *User) GetCountry() int32 {
+	if m != nil {
+		return m.Country
+	}
+	return 0
+}
+
+func (m *User) GetCity() *wrappers.StringValue {
+	if m != nil {
+		return m.City
+	}
+	return nil
+}
+
+func (m *User) GetCaption() *wrappers.StringValue {
+	if m != nil {
+		return m.Caption
+	}
+	return nil
+}
+
+func (m *User) GetLocation() *Location {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *User) GetGender() *wrappers.Int32Value {
+	if m != nil {
+		return m.Gender
+	}
+	return nil
+}
+
+// Location mirrors models.Location.
+type Location struct {
+	Lon                  float64  `protobuf:"fixed64,1,opt,name=lon,proto3" json:"lon,omitempty"`
+	Lat                  float64  `protobuf:"fixed64,2,opt,name=lat,proto3" json:"lat,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Location) Reset()         { *m = Location{} }
+func (m *Location) String() string { return proto.CompactTextString(m) }
+func (*Location) ProtoMessage()    {}
+func (*Location) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d5b8ea28545eaa7b, []int{1}
+}
+
+func (m *Location) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Location.Unmarshal(m, b)
+}
+func (m *Location) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Location.Marshal(b, m, deterministic)
+}
+func (m *Location) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Location.Merge(m, src)
+}
+func (m *Location) XXX_Size() int {
+	return xxx_messageInfo_Location.Size(m)
+}
+func (m *Location) XXX_DiscardUnknown() {
+	xxx_messageInfo_Location.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Location proto.InternalMessageInfo
+
+func (m *Location) GetLon() float64 {
+	if m != nil {
+		return m.Lon
+	}
+	return 0
+}
+
+func (m *Location) GetLat() float64 {
+	if m != nil {
+		return m.Lat
+	}
+	return 0
+}
+
+// PumpResponse holds the summary of pumped users.
+// Spooled users were stored in the local spool and will be sent to Kafka later.
+type PumpResponse struct {
+	Received             int64        `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"`
+	Sent                 int64        `protobuf:"varint,2,opt,name=sent,proto3" json:"sent,omitempty"`
+	Errors               []*PumpError `protobuf:"bytes,3,rep,name=errors,proto3" json:"errors,omitempty"`
+	Spooled              int64        `protobuf:"varint,4,opt,name=spooled,proto3" json:"spooled,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *PumpResponse) Reset()         { *m = PumpResponse{} }
+func (m *PumpResponse) String() string { return proto.CompactTextString(m) }
+func (*PumpResponse) ProtoMessage()    {}
+func (*PumpResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d5b8ea28545eaa7b, []int{2}
+}
+
+func (m *PumpResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PumpResponse.Unmarshal(m, b)
+}
+func (m *PumpResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PumpResponse.Marshal(b, m, deterministic)
+}
+func (m *PumpResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PumpResponse.Merge(m, src)
+}
+func (m *PumpResponse) XXX_Size() int {
+	return xxx_messageInfo_PumpResponse.Size(m)
+}
+func (m *PumpResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PumpResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PumpResponse proto.InternalMessageInfo
+
+func (m *PumpResponse) GetReceived() int64 {
+	if m != nil {
+		return m.Received
+	}
+	return 0
+}
+
+func (m *PumpResponse) GetSent() int64 {
+	if m != nil {
+		return m.Sent
+	}
+	return 0
+}
+
+func (m *PumpResponse) GetErrors() []*PumpError {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+func (m *PumpResponse) GetSpooled() int64 {
+	if m != nil {
+		return m.Spooled
+	}
+	return 0
+}
+
+// PumpError describes why particular user wasn't sent.
+type PumpError struct {
+	// index of the user in the stream
+	Index                int64    `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Id                   int64    `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	Error                string   `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PumpError) Reset()         { *m = PumpError{} }
+func (m *PumpError) String() string { return proto.CompactTextString(m) }
+func (*PumpError) ProtoMessage()    {}
+func (*PumpError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d5b8ea28545eaa7b, []int{3}
+}
+
+func (m *PumpError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PumpError.Unmarshal(m, b)
+}
+func (m *PumpError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PumpError.Marshal(b, m, deterministic)
+}
+func (m *PumpError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PumpError.Merge(m, src)
+}
+func (m *PumpError) XXX_Size() int {
+	return xxx_messageInfo_PumpError.Size(m)
+}
+func (m *PumpError) XXX_DiscardUnknown() {
+	xxx_messageInfo_PumpError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PumpError proto.InternalMessageInfo
+
+func (m *PumpError) GetIndex() int64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *PumpError) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *PumpError) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*User)(nil), "am.feeder.v1.User")
+	proto.RegisterType((*Location)(nil), "am.feeder.v1.Location")
+	proto.RegisterType((*PumpResponse)(nil), "am.feeder.v1.PumpResponse")
+	proto.RegisterType((*PumpError)(nil), "am.feeder.v1.PumpError")
+}
+
+func init() { proto.RegisterFile("pkg/api/users.proto", fileDescriptor_d5b8ea28545eaa7b) }
+
+var fileDescriptor_d5b8ea28545eaa7b = []byte{
+	// 500 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x94, 0xc1, 0x8b, 0xd3, 0x40,
+	0x14, 0xc6, 0x49, 0xd3, 0x76, 0xd3, 0xb7, 0x8b, 0xc8, 0xb8, 0xe8, 0x50, 0x45, 0x4a, 0x4f, 0xbd,
+	0x6c, 0xa2, 0x29, 0x88, 0x28, 0x8b, 0x20, 0x88, 0x2c, 0x78, 0x90, 0x88, 0x1e, 0xbc, 0x4d, 0x93,
+	0xb7, 0xe9, 0xd0, 0x64, 0x66, 0x98, 0x99, 0xec, 0x5a, 0xff, 0x00, 0x41, 0xfc, 0xa7, 0x25, 0x33,
+	0x49, 0x70, 0xd9, 0x4b, 0xbc, 0xf5, 0xcd, 0xfc, 0xbe, 0x8f, 0xd7, 0xf7, 0xbe, 0x0c, 0x3c, 0x52,
+	0x87, 0x32, 0x61, 0x8a, 0x27, 0x8d, 0x41, 0x6d, 0x62, 0xa5, 0xa5, 0x95, 0xe4, 0x8c, 0xd5, 0xf1,
+	0x35, 0x62, 0x81, 0x3a, 0xbe, 0x79, 0xb9, 0x7c, 0x5e, 0x4a, 0x59, 0x56, 0x98, 0xb8, 0xbb, 0x5d,
+	0x73, 0x9d, 0xdc, 0x6a, 0xa6, 0xd4, 0x40, 0xaf, 0xff, 0x4c, 0x61, 0xfa, 0xd5, 0xa0, 0x26, 0x0f,
+	0x60, 0xc2, 0x0b, 0x1a, 0xac, 0x82, 0x4d, 0x98, 0x4d, 0x78, 0x41, 0x52, 0x98, 0x61, 0xcd, 0x78,
+	0x45, 0x27, 0xab, 0x60, 0x73, 0x9a, 0x3e, 0x8b, 0xbd, 0x51, 0xdc, 0x1b, 0xc5, 0x5f, 0xac, 0xe6,
+	0xa2, 0xfc, 0xc6, 0xaa, 0x06, 0x33, 0x8f, 0x92, 0x18, 0xc2, 0x42, 0xee, 0x68, 0x38, 0x42, 0xd1,
+	0x82, 0x64, 0x0b, 0xf3, 0x5b, 0xe4, 0xe5, 0xde, 0xd2, 0xa9, 0x93, 0x3c, 0xbd, 0x27, 0xb9, 0x12,
+	0x76, 0x9b, 0x7a, 0x45, 0x87, 0xb6, 0xa2, 0xbd, 0x17, 0xcd, 0x46, 0x88, 0x3c, 0x4a, 0x5e, 0x43,
+	0x24, 0x78, 0x7e, 0x10, 0xac, 0x46, 0x3a, 0x1f, 0xd1, 0xde, 0x40, 0x13, 0x0a, 0x27, 0xb9, 0x6c,
+	0x84, 0xd5, 0x47, 0x7a, 0xb2, 0x0a, 0x36, 0xb3, 0xac, 0x2f, 0xc9, 0x0b, 0x98, 0xe6, 0xdc, 0x1e,
+	0x69, 0x34, 0xc2, 0xcf, 0x91, 0xe4, 0x15, 0x9c, 0xe4, 0x4c, 0x59, 0x2e, 0x05, 0x5d, 0x8c, 0x10,
+	0xf5, 0x30, 0x49, 0x21, 0xaa, 0x64, 0xce, 0x9c, 0x10, 0x9c, 0xf0, 0x71, 0xfc, 0xef, 0x96, 0xe3,
+	0x4f, 0xdd, 0x6d, 0x36, 0x70, 0xed, 0x98, 0x4a, 0x14, 0x05, 0x6a, 0x7a, 0x3a, 0x62, 0x4c, 0x1e,
+	0x5d, 0xc7, 0x10, 0xf5, 0x56, 0xe4, 0x21, 0x84, 0x95, 0x14, 0x2e, 0x11, 0x41, 0x16, 0x56, 0xdd,
+	0x09, 0xb3, 0x74, 0xd2, 0x9d, 0x30, 0xbb, 0xfe, 0x1d, 0xc0, 0xd9, 0xe7, 0xa6, 0x56, 0x19, 0x1a,
+	0x25, 0x85, 0x41, 0xb2, 0x84, 0x48, 0x63, 0x8e, 0xfc, 0x06, 0xfb, 0x2c, 0x0d, 0x35, 0x21, 0x30,
+	0x35, 0x28, 0xbc, 0x3e, 0xcc, 0xdc, 0x6f, 0x92, 0xc0, 0x1c, 0xb5, 0x96, 0xda, 0xd0, 0x70, 0x15,
+	0x6e, 0x4e, 0xd3, 0x27, 0x77, 0xff, 0x57, 0xeb, 0xfd, 0xa1, 0xbd, 0xcf, 0x3a, 0xac, 0x5d, 0x87,
+	0x51, 0x52, 0x56, 0x58, 0xb8, 0xcc, 0x84, 0x59, 0x5f, 0xae, 0x3f, 0xc2, 0x62, 0xc0, 0xc9, 0x39,
+	0xcc, 0xb8, 0x28, 0xf0, 0x47, 0xd7, 0x84, 0x2f, 0xba, 0x8c, 0x4f, 0x86, 0x8c, 0x9f, 0xc3, 0xcc,
+	0xd9, 0xba, 0xc4, 0x2e, 0x32, 0x5f, 0xa4, 0xbf, 0x02, 0x58, 0x5c, 0x89, 0x12, 0x8d, 0x1b, 0xc3,
+	0x1b, 0x88, 0x5a, 0x5b, 0xf7, 0x8d, 0x90, 0xbb, 0xdd, 0xb5, 0x67, 0xcb, 0xe5, 0xfd, 0x8e, 0x87,
+	0x69, 0x5c, 0xfa, 0x96, 0x5a, 0xce, 0xfc, 0xaf, 0x78, 0x13, 0xbc, 0x7f, 0xf7, 0xfd, 0xb2, 0xe4,
+	0x76, 0xdf, 0xec, 0xe2, 0x5c, 0xd6, 0x49, 0xcd, 0x2c, 0x36, 0xe6, 0x67, 0x71, 0xac, 0xb9, 0x30,
+	0x07, 0x9e, 0xb0, 0xfa, 0x42, 0x71, 0x85, 0x15, 0x17, 0x98, 0x78, 0x8b, 0x8b, 0xf6, 0x29, 0xe8,
+	0x9e, 0x84, 0xb7, 0x4c, 0xf1, 0xdd, 0xdc, 0xed, 0x7a, 0xfb, 0x77, 0x00, 0xa2, 0xf3, 0x9f, 0x03,
+	0x28, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// IngestionClient is the client API for Ingestion service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type IngestionClient interface {
+	// PumpUser sends single user to Kafka.
+	PumpUser(ctx context.Context, in *User, opts ...grpc.CallOption) (*PumpResponse, error)
+	// PumpUsers sends stream of users to Kafka.
+	PumpUsers(ctx context.Context, opts ...grpc.CallOption) (Ingestion_PumpUsersClient, error)
+}
+
+type ingestionClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewIngestionClient(cc *grpc.ClientConn) IngestionClient {
+	return &ingestionClient{cc}
+}
+
+func (c *ingestionClient) PumpUser(ctx context.Context, in *User, opts ...grpc.CallOption) (*PumpResponse, error) {
+	out := new(PumpResponse)
+	err := c.cc.Invoke(ctx, "/am.feeder.v1.Ingestion/PumpUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ingestionClient) PumpUsers(ctx context.Context, opts ...grpc.CallOption) (Ingestion_PumpUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Ingestion_serviceDesc.Streams[0], "/am.feeder.v1.Ingestion/PumpUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ingestionPumpUsersClient{stream}
+	return x, nil
+}
+
+type Ingestion_PumpUsersClient interface {
+	Send(*User) error
+	CloseAndRecv() (*PumpResponse, error)
+	grpc.ClientStream
+}
+
+type ingestionPumpUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *ingestionPumpUsersClient) Send(m *User) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *ingestionPumpUsersClient) CloseAndRecv() (*PumpResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PumpResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IngestionServer is the server API for Ingestion service.
+type IngestionServer interface {
+	// PumpUser sends single user to Kafka.
+	PumpUser(context.Context, *User) (*PumpResponse, error)
+	// PumpUsers sends stream of users to Kafka.
+	PumpUsers(Ingestion_PumpUsersServer) error
+}
+
+// UnimplementedIngestionServer can be embedded to have forward compatible implementations.
+type UnimplementedIngestionServer struct {
+}
+
+func (*UnimplementedIngestionServer) PumpUser(ctx context.Context, req *User) (*PumpResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PumpUser not implemented")
+}
+func (*UnimplementedIngestionServer) PumpUsers(srv Ingestion_PumpUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method PumpUsers not implemented")
+}
+
+func RegisterIngestionServer(s *grpc.Server, srv IngestionServer) {
+	s.RegisterService(&_Ingestion_serviceDesc, srv)
+}
+
+func _Ingestion_PumpUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(User)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IngestionServer).PumpUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/am.feeder.v1.Ingestion/PumpUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IngestionServer).PumpUser(ctx, req.(*User))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ingestion_PumpUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IngestionServer).PumpUsers(&ingestionPumpUsersServer{stream})
+}
+
+type Ingestion_PumpUsersServer interface {
+	SendAndClose(*PumpResponse) error
+	Recv() (*User, error)
+	grpc.ServerStream
+}
+
+type ingestionPumpUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *ingestionPumpUsersServer) SendAndClose(m *PumpResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *ingestionPumpUsersServer) Recv() (*User, error) {
+	m := new(User)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _Ingestion_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "am.feeder.v1.Ingestion",
+	HandlerType: (*IngestionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PumpUser",
+			Handler:    _Ingestion_PumpUser_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PumpUsers",
+			Handler:       _Ingestion_PumpUsers_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pkg/api/users.proto",
+}
@@ -4,13 +4,35 @@ import (
 	"io/ioutil"
 
 	"github.com/BurntSushi/toml"
+	"github.com/mateuszdyminski/am-pipeline/models/kafkaconfig"
 )
 
 // Config holds configuration of feeder.
 type Config struct {
-	Brokers  []string
-	Topic    string
+	Brokers []string
+	Topic   string
+	// Kafka holds TLS and SASL settings of the connection to brokers.
+	Kafka kafkaconfig.Config
+	// RequiredAcks says when Kafka acknowledges the user: all, local or none.
+	RequiredAcks string
+	// QueueSize limits the number of users waiting for Kafka acknowledgement.
+	QueueSize int
+	// AckTimeoutSec limits the time request waits for Kafka acknowledgement.
+	AckTimeoutSec int
+
+	// SpoolDir enables local spool for users which can't be sent to Kafka.
+	SpoolDir string
+	// SpoolMaxMB limits the size of the spool.
+	SpoolMaxMB int
+	// SpoolSegmentMB is the size of the single spool segment file.
+	SpoolSegmentMB int
+
 	HTTPPort int
+	// GRPCPort enables gRPC ingestion server when greater than 0.
+	GRPCPort int
+
+	// MaxUploadMB limits the size of files accepted by POST /uploads.
+	MaxUploadMB int
 }
 
 // LoadConfig loads config from env vars.
@@ -1,32 +1,139 @@
 package pumper
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 
 	"github.com/Shopify/sarama"
 	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// DefaultQueueSize is used when QueueSize is not set in config.
+	DefaultQueueSize = 10000
+	// DefaultAckTimeout is used when AckTimeoutSec is not set in config.
+	DefaultAckTimeout = 10 * time.Second
+	// retryDelay is the time between attempts of enqueueing when queue is saturated.
+	retryDelay = 100 * time.Millisecond
+	// drainBatchSize is the max number of spooled users sent to Kafka together.
+	drainBatchSize = 500
+	// maxDrainBackoff limits the time between attempts of draining the spool.
+	maxDrainBackoff = 30 * time.Second
+)
+
+var (
+	// ErrQueueFull is returned when there is no room in the queue for the whole batch.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrBatchTooLarge is returned when batch is larger than the queue.
+	ErrBatchTooLarge = errors.New("batch is larger than the queue")
+	// ErrClosed is returned when Pumper is already closed.
+	ErrClosed = errors.New("pumper is closed")
+)
+
+// Message holds single record sent to Kafka.
+type Message struct {
+	Key   string
+	Value []byte
+}
+
+// MessageError describes why particular message of the batch wasn't sent.
+type MessageError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// Result describes the delivery state of the batch.
+// Messages are Sent only when acknowledged by Kafka with RequiredAcks.
+// Pending messages were accepted but not acknowledged before the timeout.
+// Spooled messages were synced to the local spool and will be sent to Kafka later.
+type Result struct {
+	RequiredAcks string         `json:"requiredAcks"`
+	Accepted     int            `json:"accepted"`
+	Sent         int            `json:"sent"`
+	Spooled      int            `json:"spooled"`
+	Failed       int            `json:"failed"`
+	Pending      int            `json:"pending"`
+	Errors       []MessageError `json:"errors,omitempty"`
+
+	// indexes of failed and pending messages
+	undelivered []int
+}
+
+// batch tracks acknowledgements of messages sent together.
+type batch struct {
+	mu        sync.Mutex
+	result    Result
+	delivered []bool
+	remaining int
+	done      chan struct{}
+}
+
+// ack is attached to every message as metadata.
+type ack struct {
+	b     *batch
+	index int
+}
+
 // Pumper allows to pump data into Kafka
 type Pumper struct {
-	cfg      *config.Config
-	producer sarama.SyncProducer
+	cfg          *config.Config
+	client       sarama.Client
+	producer     sarama.AsyncProducer
+	spool        *Spool
+	requiredAcks string
+	queueSize    int
+	ackTimeout   time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	stopped chan struct{}
+	stop    chan struct{}
+	drained chan struct{}
+
+	qmu      sync.Mutex
+	inFlight int
+
 	sent     *prometheus.CounterVec
 	sentErr  *prometheus.CounterVec
+	queued   prometheus.Gauge
+	rejected prometheus.Counter
+	spooled  prometheus.Counter
+
+	kafkaCheck checks.Probe
 }
 
 // NewPumper creates new Pumper.
 func NewPumper(cfg *config.Config) (*Pumper, error) {
+	requiredAcks, err := parseRequiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+
 	config := sarama.NewConfig()
 	config.Version = sarama.V2_3_0_0
 	config.Producer.Retry.Max = 10
 	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.RequiredAcks = requiredAcks
+	config.Producer.Flush.Frequency = 5 * time.Millisecond
 	config.Producer.Partitioner = sarama.NewRandomPartitioner
+	if err := cfg.Kafka.Apply(config); err != nil {
+		return nil, fmt.Errorf("can't set up kafka security: %w", err)
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("can't create kafka client: %w", err)
+	}
 
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	producer, err := sarama.NewAsyncProducerFromClient(client)
 	if err != nil {
 		return nil, fmt.Errorf("can't create kafka producer: %w", err)
 	}
@@ -51,34 +158,492 @@ func NewPumper(cfg *config.Config) (*Pumper, error) {
 		[]string{"topic"},
 	)
 
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "am",
+		Subsystem: "feeder_api",
+		Name:      "queue_size",
+		Help:      "The number of users waiting for Kafka acknowledgement.",
+	})
+
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "am",
+		Subsystem: "feeder_api",
+		Name:      "queue_rejected_total",
+		Help:      "The total number of batches rejected because of saturated queue.",
+	})
+
 	prometheus.Register(sent)
 	prometheus.Register(sentErr)
+	prometheus.Register(queued)
+	prometheus.Register(rejected)
+
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	ackTimeout := time.Duration(cfg.AckTimeoutSec) * time.Second
+	if ackTimeout <= 0 {
+		ackTimeout = DefaultAckTimeout
+	}
 
 	pumper := &Pumper{
-		cfg:      cfg,
-		producer: producer,
-		sent:     sent,
-		sentErr:  sentErr,
+		cfg:          cfg,
+		client:       client,
+		producer:     producer,
+		requiredAcks: cfg.RequiredAcks,
+		queueSize:    queueSize,
+		ackTimeout:   ackTimeout,
+		stopped:      make(chan struct{}),
+		stop:         make(chan struct{}),
+		drained:      make(chan struct{}),
+		sent:         sent,
+		sentErr:      sentErr,
+		queued:       queued,
+		rejected:     rejected,
+	}
+	if pumper.requiredAcks == "" {
+		pumper.requiredAcks = "local"
+	}
+	pumper.kafkaCheck = checks.Blocking(pumper.checkKafka)
+
+	if cfg.SpoolDir != "" {
+		if err := pumper.openSpool(); err != nil {
+			return nil, err
+		}
+	}
+
+	go pumper.dispatch()
+
+	if pumper.spool != nil {
+		go pumper.drain()
+	} else {
+		close(pumper.drained)
 	}
 
 	return pumper, nil
 }
 
-// Pump sends payload to Apache Kafka.
-func (p *Pumper) Pump(key string, data []byte) error {
-	message := &sarama.ProducerMessage{
-		Topic:     p.cfg.Topic,
-		Value:     sarama.ByteEncoder(data),
-		Timestamp: time.Now(),
+func parseRequiredAcks(acks string) (sarama.RequiredAcks, error) {
+	switch acks {
+	case "", "local":
+		return sarama.WaitForLocal, nil
+	case "all":
+		return sarama.WaitForAll, nil
+	case "none":
+		return sarama.NoResponse, nil
 	}
 
-	_, _, err := p.producer.SendMessage(message)
+	return 0, fmt.Errorf("unknown RequiredAcks: %s. Allowed: all, local, none", acks)
+}
 
+// Pump sends payload to Apache Kafka and waits for the acknowledgement. Waiting for the room in the queue
+// and for the acknowledgement is limited by the ack timeout and the context.
+func (p *Pumper) Pump(ctx context.Context, key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, p.ackTimeout)
+	defer cancel()
+
+	res, err := p.PumpBatchWait(ctx, []Message{{Key: key, Value: data}})
 	if err != nil {
-		p.sentErr.WithLabelValues(p.cfg.Topic).Inc()
+		return err
+	}
+
+	if res.Failed > 0 {
+		return errors.New(res.Errors[0].Error)
+	}
+
+	if res.Pending > 0 {
+		return errors.New("timeout while waiting for kafka acknowledgement")
+	}
+
+	return nil
+}
+
+// PumpBatch enqueues messages and waits until all of them are acknowledged by Kafka,
+// the ack timeout passes or the context is done. It returns ErrQueueFull immediately
+// when there is no room in the queue for the whole batch.
+//
+// When the spool is enabled, messages which can't be delivered to Kafka are written to the spool instead.
+// While the spool is not empty all messages go there, so they are sent to Kafka in order.
+func (p *Pumper) PumpBatch(ctx context.Context, msgs []Message) (Result, error) {
+	if p.spool == nil {
+		return p.send(ctx, msgs)
+	}
+
+	if !p.spool.Empty() {
+		return p.spoolAll(msgs)
+	}
+
+	res, err := p.send(ctx, msgs)
+	if err == ErrQueueFull {
+		return p.spoolAll(msgs)
+	}
+	if err != nil || len(res.undelivered) == 0 {
+		return res, err
+	}
+
+	undelivered := make([]Message, 0, len(res.undelivered))
+	for _, i := range res.undelivered {
+		undelivered = append(undelivered, msgs[i])
+	}
+
+	// messages which weren't acknowledged in time may still reach Kafka, so they could be duplicated
+	if err := p.spool.Append(undelivered); err != nil {
+		log.Errorf("can't spool undelivered users: %v", err)
+		return res, nil
+	}
+
+	p.spooled.Add(float64(len(undelivered)))
+	res.Spooled = len(undelivered)
+	res.Failed = 0
+	res.Pending = 0
+	res.Errors = nil
+
+	return res, nil
+}
+
+// spoolAll writes all messages to the spool.
+func (p *Pumper) spoolAll(msgs []Message) (Result, error) {
+	if err := p.spool.Append(msgs); err != nil {
+		if err == ErrSpoolFull {
+			p.rejected.Inc()
+			return Result{}, ErrQueueFull
+		}
+		return Result{}, err
+	}
+
+	p.spooled.Add(float64(len(msgs)))
+	return Result{RequiredAcks: p.requiredAcks, Accepted: len(msgs), Spooled: len(msgs)}, nil
+}
+
+// send enqueues messages to Kafka producer and waits for the acknowledgements.
+func (p *Pumper) send(ctx context.Context, msgs []Message) (Result, error) {
+	if len(msgs) > p.queueSize {
+		return Result{}, ErrBatchTooLarge
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return Result{}, ErrClosed
+	}
+
+	if err := p.reserve(len(msgs)); err != nil {
+		p.mu.RUnlock()
+		return Result{}, err
+	}
+
+	b := &batch{
+		result:    Result{RequiredAcks: p.requiredAcks, Accepted: len(msgs)},
+		delivered: make([]bool, len(msgs)),
+		remaining: len(msgs),
+		done:      make(chan struct{}),
+	}
+	if len(msgs) == 0 {
+		close(b.done)
+	}
+
+	now := time.Now()
+	for i, msg := range msgs {
+		p.producer.Input() <- &sarama.ProducerMessage{
+			Topic:     p.cfg.Topic,
+			Key:       sarama.StringEncoder(msg.Key),
+			Value:     sarama.ByteEncoder(msg.Value),
+			Timestamp: now,
+			Metadata:  &ack{b: b, index: i},
+		}
+	}
+	p.mu.RUnlock()
+
+	timer := time.NewTimer(p.ackTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-b.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	return b.snapshot(), nil
+}
+
+// QueueSize returns the max number of messages waiting for Kafka acknowledgement.
+func (p *Pumper) QueueSize() int {
+	return p.queueSize
+}
+
+// PumpBatchWait works like PumpBatch but waits for room in the queue instead of returning ErrQueueFull.
+func (p *Pumper) PumpBatchWait(ctx context.Context, msgs []Message) (Result, error) {
+	for {
+		res, err := p.PumpBatch(ctx, msgs)
+		if err != ErrQueueFull {
+			return res, err
+		}
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+}
+
+// Close flushes queued messages and closes Kafka producer.
+func (p *Pumper) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.drained
+
+	p.producer.AsyncClose()
+	<-p.stopped
+
+	if p.spool != nil {
+		if err := p.spool.Close(); err != nil {
+			return err
+		}
+	}
+
+	return p.client.Close()
+}
+
+// reserve takes n slots of the queue.
+func (p *Pumper) reserve(n int) error {
+	p.qmu.Lock()
+	defer p.qmu.Unlock()
+
+	if p.inFlight+n > p.queueSize {
+		p.rejected.Inc()
+		return ErrQueueFull
+	}
+
+	p.inFlight += n
+	p.queued.Set(float64(p.inFlight))
+	return nil
+}
+
+// release frees n slots of the queue.
+func (p *Pumper) release(n int) {
+	p.qmu.Lock()
+	p.inFlight -= n
+	p.queued.Set(float64(p.inFlight))
+	p.qmu.Unlock()
+}
+
+// dispatch passes Kafka acknowledgements to the batches.
+func (p *Pumper) dispatch() {
+	defer close(p.stopped)
+
+	successes, errs := p.producer.Successes(), p.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			p.sent.WithLabelValues(p.cfg.Topic).Inc()
+			p.ack(msg, nil)
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			p.sentErr.WithLabelValues(p.cfg.Topic).Inc()
+			p.ack(perr.Msg, perr.Err)
+		}
+	}
+}
+
+func (p *Pumper) ack(msg *sarama.ProducerMessage, err error) {
+	p.release(1)
+
+	a, ok := msg.Metadata.(*ack)
+	if !ok {
+		return
+	}
+
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if err != nil {
+		a.b.result.Failed++
+		a.b.result.Errors = append(a.b.result.Errors, MessageError{Index: a.index, Error: err.Error()})
 	} else {
-		p.sent.WithLabelValues(p.cfg.Topic).Inc()
+		a.b.result.Sent++
+		a.b.delivered[a.index] = true
+	}
+
+	a.b.remaining--
+	if a.b.remaining == 0 {
+		close(a.b.done)
+	}
+}
+
+func (b *batch) snapshot() Result {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := b.result
+	res.Errors = append([]MessageError(nil), b.result.Errors...)
+	res.Pending = b.remaining
+	for i, delivered := range b.delivered {
+		if !delivered {
+			res.undelivered = append(res.undelivered, i)
+		}
+	}
+	return res
+}
+
+func (p *Pumper) openSpool() error {
+	maxBytes := int64(p.cfg.SpoolMaxMB) << 20
+	if maxBytes <= 0 {
+		return errors.New("SpoolMaxMB must be set when spool is enabled")
+	}
+
+	segmentBytes := int64(p.cfg.SpoolSegmentMB) << 20
+	if segmentBytes <= 0 {
+		segmentBytes = DefaultSpoolSegmentMB << 20
+	}
+
+	spool, err := OpenSpool(p.cfg.SpoolDir, maxBytes, segmentBytes)
+	if err != nil {
+		return fmt.Errorf("can't open spool: %w", err)
+	}
+	p.spool = spool
+
+	p.spooled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "am",
+		Subsystem: "feeder_api",
+		Name:      "spooled_total",
+		Help:      "The total number of users written to the spool.",
+	})
+
+	size := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "am",
+		Subsystem: "feeder_api",
+		Name:      "spool_size_bytes",
+		Help:      "The number of bytes used by the spool.",
+	}, func() float64 { return float64(spool.Size()) })
+
+	age := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "am",
+		Subsystem: "feeder_api",
+		Name:      "spool_oldest_age_seconds",
+		Help:      "The age of the oldest user waiting in the spool.",
+	}, func() float64 { return spool.Age().Seconds() })
+
+	prometheus.Register(p.spooled)
+	prometheus.Register(size)
+	prometheus.Register(age)
+
+	return nil
+}
+
+// drain sends spooled messages to Kafka in order. Batch is removed from the spool only when
+// all its messages are acknowledged, otherwise it's retried with backoff.
+func (p *Pumper) drain() {
+	defer close(p.drained)
+
+	batchSize := drainBatchSize
+	if batchSize > p.queueSize {
+		batchSize = p.queueSize
+	}
+
+	// waiting for acknowledgements ends on Close, unacknowledged messages stay in the spool
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := time.Second
+	for {
+		if p.spool.Empty() {
+			select {
+			case <-p.spool.Notify():
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+
+		msgs, next, err := p.spool.Peek(batchSize)
+		if err == nil && len(msgs) > 0 {
+			var res Result
+			res, err = p.sendWait(ctx, msgs)
+			if err == nil && res.Sent != len(msgs) {
+				err = fmt.Errorf("%d of %d users not delivered", len(msgs)-res.Sent, len(msgs))
+			}
+		}
+
+		if err == nil {
+			if err = p.spool.Commit(next); err == nil {
+				backoff = time.Second
+				continue
+			}
+		}
+
+		log.Errorf("can't drain spool, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-p.stop:
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxDrainBackoff {
+			backoff = maxDrainBackoff
+		}
+	}
+}
+
+// sendWait sends messages to Kafka waiting for the room in the queue until the context is done.
+func (p *Pumper) sendWait(ctx context.Context, msgs []Message) (Result, error) {
+	for {
+		res, err := p.send(ctx, msgs)
+		if err != ErrQueueFull {
+			return res, err
+		}
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return Result{}, ErrClosed
+		}
+	}
+}
+
+// CheckKafka fetches metadata of the topic to verify that Kafka brokers are reachable.
+// Kafka client doesn't accept the context, so the check returns once the context is done
+// and later checks wait for the pending fetch.
+func (p *Pumper) CheckKafka(ctx context.Context) error {
+	return p.kafkaCheck(ctx)
+}
+
+func (p *Pumper) checkKafka() error {
+	if err := p.client.RefreshMetadata(p.cfg.Topic); err != nil {
+		return fmt.Errorf("can't fetch metadata: %w", err)
+	}
+
+	partitions, err := p.client.WritablePartitions(p.cfg.Topic)
+	if err != nil {
+		return fmt.Errorf("can't get partitions: %w", err)
+	}
+
+	if len(partitions) == 0 {
+		return errors.New("no writable partitions")
 	}
 
-	return err
+	return nil
 }
@@ -0,0 +1,472 @@
+package pumper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSpoolSegmentMB is used when SpoolSegmentMB is not set in config.
+	DefaultSpoolSegmentMB = 16
+
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+
+	// record header: crc32, timestamp, key length, value length
+	headerSize = 4 + 8 + 4 + 4
+	// maxRecordSize protects from huge allocations when the length is corrupted
+	maxRecordSize = 64 << 20
+)
+
+var (
+	// ErrSpoolFull is returned when records don't fit into the spool.
+	ErrSpoolFull = errors.New("spool is full")
+	// ErrSpoolClosed is returned when records are appended to the closed spool.
+	ErrSpoolClosed = errors.New("spool is closed")
+)
+
+// position points to the record in the spool.
+type position struct {
+	seq int64
+	off int64
+}
+
+// Spool is a disk-backed FIFO queue of messages stored in append-only segment files.
+// Every record is written with its checksum, so a torn write at the end of the segment is truncated on open.
+type Spool struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	segBytes int64
+	segments []int64
+	sizes    map[int64]int64
+	w        *os.File
+	cursor   position
+	oldest   time.Time
+	notify   chan struct{}
+	closed   bool
+}
+
+// OpenSpool opens spool in given directory or creates the new one.
+func OpenSpool(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create spool dir: %w", err)
+	}
+
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		segBytes: segmentBytes,
+		sizes:    make(map[int64]int64),
+		notify:   make(chan struct{}, 1),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't list spool dir: %w", err)
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+
+		var seq int64
+		if _, err := fmt.Sscanf(f.Name(), "%d"+segmentExt, &seq); err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, seq)
+		s.sizes[seq] = f.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if len(s.segments) == 0 {
+		s.segments = []int64{1}
+		s.sizes[1] = 0
+	}
+
+	last := s.segments[len(s.segments)-1]
+	if err := s.repair(last); err != nil {
+		return nil, err
+	}
+
+	if s.w, err = os.OpenFile(s.path(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, fmt.Errorf("can't open spool segment: %w", err)
+	}
+
+	if err := s.loadCursor(); err != nil {
+		return nil, err
+	}
+
+	if !s.empty() {
+		// age of the spool is taken from the timestamp of the oldest record, which is set by Peek
+		if _, _, err := s.Peek(1); err != nil {
+			return nil, err
+		}
+		s.signal()
+	}
+
+	return s, nil
+}
+
+// Append writes messages at the end of the spool and syncs them to disk.
+func (s *Spool) Append(msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSpoolClosed
+	}
+
+	var size int64
+	for _, msg := range msgs {
+		size += int64(headerSize + len(msg.Key) + len(msg.Value))
+	}
+
+	if s.size()+size > s.maxBytes {
+		return ErrSpoolFull
+	}
+
+	wasEmpty := s.empty()
+	now := time.Now()
+	for _, msg := range msgs {
+		if err := s.rollIfNeeded(); err != nil {
+			return err
+		}
+
+		n, err := s.w.Write(encodeRecord(now, msg))
+		if err != nil {
+			return s.truncate(n, err)
+		}
+		s.sizes[s.active()] += int64(n)
+	}
+
+	if err := s.w.Sync(); err != nil {
+		return fmt.Errorf("can't sync spool: %w", err)
+	}
+
+	if wasEmpty {
+		s.oldest = now
+	}
+	s.signal()
+
+	return nil
+}
+
+// Peek reads up to n records starting from the cursor. Returned position should be passed to Commit
+// once the records are delivered.
+func (s *Spool) Peek(n int) ([]Message, position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos := s.cursor
+	var msgs []Message
+	var first time.Time
+
+	for len(msgs) < n {
+		if pos.off >= s.sizes[pos.seq] {
+			if pos.seq == s.active() {
+				break
+			}
+			pos = position{seq: s.next(pos.seq)}
+			continue
+		}
+
+		f, err := os.Open(s.path(pos.seq))
+		if err != nil {
+			return nil, s.cursor, fmt.Errorf("can't open spool segment: %w", err)
+		}
+
+		if _, err := f.Seek(pos.off, io.SeekStart); err != nil {
+			f.Close()
+			return nil, s.cursor, fmt.Errorf("can't seek spool segment: %w", err)
+		}
+
+		r := bufio.NewReader(f)
+		for len(msgs) < n && pos.off < s.sizes[pos.seq] {
+			ts, msg, size, err := decodeRecord(r)
+			if err != nil {
+				f.Close()
+				return nil, s.cursor, fmt.Errorf("can't read spool segment %d at %d: %w", pos.seq, pos.off, err)
+			}
+
+			if first.IsZero() {
+				first = ts
+			}
+			msgs = append(msgs, msg)
+			pos.off += size
+		}
+		f.Close()
+	}
+
+	if !first.IsZero() {
+		s.oldest = first
+	}
+
+	return msgs, pos, nil
+}
+
+// Commit moves the cursor and removes fully delivered segments.
+func (s *Spool) Commit(pos position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 1 && s.segments[0] < pos.seq {
+		seq := s.segments[0]
+		if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't remove spool segment: %w", err)
+		}
+		delete(s.sizes, seq)
+		s.segments = s.segments[1:]
+	}
+
+	s.cursor = pos
+	if s.empty() {
+		s.oldest = time.Time{}
+	}
+
+	return s.saveCursor()
+}
+
+// Empty returns true when all records were delivered.
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.empty()
+}
+
+// Size returns the number of bytes used by the spool segments.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size()
+}
+
+// Age returns the age of the oldest not delivered record.
+func (s *Spool) Age() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oldest.IsZero() {
+		return 0
+	}
+
+	return time.Since(s.oldest)
+}
+
+// Notify returns channel signaled when new records are appended.
+func (s *Spool) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Close closes active segment. Records can't be appended to the closed spool, but the ones
+// already written can still be read.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	return s.w.Close()
+}
+
+func (s *Spool) empty() bool {
+	return s.cursor.seq == s.active() && s.cursor.off >= s.sizes[s.active()]
+}
+
+func (s *Spool) size() int64 {
+	var size int64
+	for _, seq := range s.segments {
+		size += s.sizes[seq]
+	}
+
+	return size
+}
+
+func (s *Spool) active() int64 {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Spool) next(seq int64) int64 {
+	for _, n := range s.segments {
+		if n > seq {
+			return n
+		}
+	}
+
+	return seq
+}
+
+func (s *Spool) path(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// rollIfNeeded starts the new segment when the active one is full.
+func (s *Spool) rollIfNeeded() error {
+	if s.sizes[s.active()] < s.segBytes {
+		return nil
+	}
+
+	if err := s.w.Sync(); err != nil {
+		return fmt.Errorf("can't sync spool segment: %w", err)
+	}
+
+	if err := s.w.Close(); err != nil {
+		return fmt.Errorf("can't close spool segment: %w", err)
+	}
+
+	seq := s.active() + 1
+	w, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("can't create spool segment: %w", err)
+	}
+
+	s.w = w
+	s.segments = append(s.segments, seq)
+	s.sizes[seq] = 0
+
+	return nil
+}
+
+// truncate cuts off n bytes of the record which failed to be written to the active segment, so the next records
+// are written right after the last valid one. When it can't be done, the bytes are kept in the size of the segment,
+// so offsets of the next records stay right and reading stops at the torn record.
+func (s *Spool) truncate(n int, err error) error {
+	if n == 0 {
+		return fmt.Errorf("can't write to spool: %w", err)
+	}
+
+	if terr := s.w.Truncate(s.sizes[s.active()]); terr != nil {
+		s.sizes[s.active()] += int64(n)
+		return fmt.Errorf("can't write to spool: %v, can't truncate it: %w", err, terr)
+	}
+
+	return fmt.Errorf("can't write to spool: %w", err)
+}
+
+// repair truncates the segment after the last valid record.
+func (s *Spool) repair(seq int64) error {
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("can't open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		_, _, size, err := decodeRecord(r)
+		if err != nil {
+			break
+		}
+		valid += size
+	}
+
+	if valid != s.sizes[seq] {
+		if err := f.Truncate(valid); err != nil {
+			return fmt.Errorf("can't truncate spool segment: %w", err)
+		}
+		s.sizes[seq] = valid
+	}
+
+	return nil
+}
+
+func (s *Spool) loadCursor() error {
+	s.cursor = position{seq: s.segments[0]}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, cursorFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't read spool cursor: %w", err)
+	}
+
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.seq, &pos.off); err != nil {
+		return fmt.Errorf("can't parse spool cursor: %w", err)
+	}
+
+	if _, ok := s.sizes[pos.seq]; ok && pos.off <= s.sizes[pos.seq] {
+		s.cursor = pos
+	}
+
+	return nil
+}
+
+func (s *Spool) saveCursor() error {
+	tmp := filepath.Join(s.dir, cursorFile+".tmp")
+	data := []byte(fmt.Sprintf("%d %d\n", s.cursor.seq, s.cursor.off))
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("can't write spool cursor: %w", err)
+	}
+
+	return os.Rename(tmp, filepath.Join(s.dir, cursorFile))
+}
+
+func encodeRecord(ts time.Time, msg Message) []byte {
+	buf := make([]byte, headerSize+len(msg.Key)+len(msg.Value))
+	binary.BigEndian.PutUint64(buf[4:], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(buf[12:], uint32(len(msg.Key)))
+	binary.BigEndian.PutUint32(buf[16:], uint32(len(msg.Value)))
+	copy(buf[headerSize:], msg.Key)
+	copy(buf[headerSize+len(msg.Key):], msg.Value)
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+func decodeRecord(r io.Reader) (time.Time, Message, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return time.Time{}, Message{}, 0, err
+	}
+
+	keyLen := binary.BigEndian.Uint32(header[12:])
+	valLen := binary.BigEndian.Uint32(header[16:])
+	if int64(keyLen)+int64(valLen) > maxRecordSize {
+		return time.Time{}, Message{}, 0, errors.New("record too large")
+	}
+
+	body := make([]byte, int(keyLen)+int(valLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return time.Time{}, Message{}, 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header) {
+		return time.Time{}, Message{}, 0, errors.New("checksum mismatch")
+	}
+
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(header[4:])))
+	msg := Message{Key: string(body[:keyLen]), Value: body[keyLen:]}
+
+	return ts, msg, int64(headerSize + len(body)), nil
+}
@@ -0,0 +1,285 @@
+package pumper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// record of the key "kN" and the value "vN" takes 24 bytes
+const testRecordSize = headerSize + 4
+
+func testMessages(from, to int) []Message {
+	var msgs []Message
+	for i := from; i < to; i++ {
+		msgs = append(msgs, Message{Key: fmt.Sprintf("k%d", i), Value: []byte(fmt.Sprintf("v%d", i))})
+	}
+
+	return msgs
+}
+
+func tempSpoolDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestSpoolRollOver(t *testing.T) {
+	tests := []struct {
+		name     string
+		segBytes int64
+		appended int
+		segments int
+	}{
+		{name: "single segment", segBytes: 10 * testRecordSize, appended: 10, segments: 1},
+		{name: "record per segment", segBytes: testRecordSize, appended: 5, segments: 5},
+		{name: "segment overflows by the record", segBytes: testRecordSize + 1, appended: 5, segments: 3},
+		{name: "three records per segment", segBytes: 3 * testRecordSize, appended: 10, segments: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempSpoolDir(t)
+			defer os.RemoveAll(dir)
+
+			s, err := OpenSpool(dir, 1<<20, tt.segBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			for i := 0; i < tt.appended; i++ {
+				if err := s.Append(testMessages(i, i+1)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(s.segments) != tt.segments {
+				t.Errorf("segments: got %v, want %d", s.segments, tt.segments)
+			}
+			if size := s.Size(); size != int64(tt.appended*testRecordSize) {
+				t.Errorf("size: got %d, want %d", size, tt.appended*testRecordSize)
+			}
+
+			msgs, pos, err := s.Peek(tt.appended + 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(msgs, testMessages(0, tt.appended)) {
+				t.Errorf("peeked %v", msgs)
+			}
+
+			if err := s.Commit(pos); err != nil {
+				t.Fatal(err)
+			}
+			if !s.Empty() || len(s.segments) != 1 {
+				t.Errorf("spool is not drained: segments %v, cursor %+v", s.segments, s.cursor)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			if len(files) != 1 {
+				t.Errorf("delivered segments are not removed: %v", files)
+			}
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		segBytes  int64
+		committed int
+	}{
+		{name: "nothing committed", segBytes: 1 << 20, committed: 0},
+		{name: "part of the segment committed", segBytes: 1 << 20, committed: 3},
+		{name: "part of the segments committed", segBytes: 2 * testRecordSize, committed: 5},
+		{name: "everything committed", segBytes: 2 * testRecordSize, committed: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempSpoolDir(t)
+			defer os.RemoveAll(dir)
+
+			s, err := OpenSpool(dir, 1<<20, tt.segBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Append(testMessages(0, 8)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.committed > 0 {
+				_, pos, err := s.Peek(tt.committed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Commit(pos); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Close()
+
+			s, err = OpenSpool(dir, 1<<20, tt.segBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			msgs, _, err := s.Peek(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testMessages(tt.committed, 8); !reflect.DeepEqual(msgs, want) {
+				t.Errorf("replayed %v, want %v", msgs, want)
+			}
+			if s.Empty() != (tt.committed == 8) {
+				t.Errorf("empty: got %v", s.Empty())
+			}
+		})
+	}
+}
+
+func TestSpoolTornWrite(t *testing.T) {
+	dir := tempSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testMessages(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// half of the record written before the crash
+	f, err := os.OpenFile(s.path(1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(s.oldest, testMessages(3, 4)[0])[:testRecordSize/2])
+	f.Close()
+
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if size := s.Size(); size != 3*testRecordSize {
+		t.Errorf("torn record is not truncated: size %d", size)
+	}
+
+	if err := s.Append(testMessages(3, 4)); err != nil {
+		t.Fatal(err)
+	}
+	msgs, _, err := s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msgs, testMessages(0, 4)) {
+		t.Errorf("peeked %v", msgs)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir := tempSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSpool(dir, 3*testRecordSize, testRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Append(testMessages(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testMessages(2, 4)); err != ErrSpoolFull {
+		t.Fatalf("got %v, want %v", err, ErrSpoolFull)
+	}
+
+	// records which don't fit are not written at all
+	msgs, pos, err := s.Peek(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msgs, testMessages(0, 2)) {
+		t.Errorf("peeked %v", msgs)
+	}
+
+	// delivered records make room for the new ones
+	if err := s.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testMessages(2, 4)); err != nil {
+		t.Errorf("can't append after commit: %v", err)
+	}
+}
+
+func TestSpoolAgeAfterReopen(t *testing.T) {
+	dir := tempSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSpool(dir, 1<<20, testRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testMessages(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	s, err = OpenSpool(dir, 1<<20, testRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if age := s.Age(); age < 50*time.Millisecond {
+		t.Errorf("age: got %s, want the age of the oldest record", age)
+	}
+}
+
+func TestSpoolClosed(t *testing.T) {
+	dir := tempSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testMessages(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Append(testMessages(1, 2)); err != ErrSpoolClosed {
+		t.Errorf("got %v, want %v", err, ErrSpoolClosed)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("can't close the spool twice: %v", err)
+	}
+
+	// written records can still be read
+	msgs, _, err := s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msgs, testMessages(0, 1)) {
+		t.Errorf("peeked %v", msgs)
+	}
+}
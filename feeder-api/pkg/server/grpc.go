@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/api"
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/pumper"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// streamBatchSize is the max number of streamed users sent to Kafka together. Batches are never larger than the queue.
+const streamBatchSize = 500
+
+// GRPCServer implements api.IngestionServer.
+type GRPCServer struct {
+	p           *pumper.Pumper
+	received    *prometheus.CounterVec
+	receivedErr *prometheus.CounterVec
+}
+
+// NewGRPCServer creates new gRPC server which pumps users with given Pumper.
+func NewGRPCServer(pumper *pumper.Pumper) *GRPCServer {
+	received, receivedErr := receivedMetrics()
+
+	return &GRPCServer{
+		p:           pumper,
+		received:    received,
+		receivedErr: receivedErr,
+	}
+}
+
+// PumpUser sends single user to Kafka.
+func (s *GRPCServer) PumpUser(ctx context.Context, user *api.User) (*api.PumpResponse, error) {
+	ip := peerIP(ctx)
+
+	msg, err := message(user)
+	if err != nil {
+		s.receivedErr.WithLabelValues(ip).Inc()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	res, err := s.p.PumpBatch(ctx, []pumper.Message{msg})
+	if err != nil {
+		s.receivedErr.WithLabelValues(ip).Inc()
+		return nil, pumpStatus(err)
+	}
+
+	s.received.WithLabelValues(ip).Inc()
+	if res.Failed > 0 {
+		return nil, status.Error(codes.Internal, res.Errors[0].Error)
+	}
+	if res.Pending > 0 {
+		return nil, status.Error(codes.DeadlineExceeded, "timeout while waiting for kafka acknowledgement")
+	}
+
+	return &api.PumpResponse{Received: 1, Sent: int64(res.Sent), Spooled: int64(res.Spooled)}, nil
+}
+
+// PumpUsers sends stream of users to Kafka in batches. Users which can't be sent are reported in the response.
+// When the queue is saturated stream is not read until there is room for the next batch.
+func (s *GRPCServer) PumpUsers(stream api.Ingestion_PumpUsersServer) error {
+	ip := peerIP(stream.Context())
+	resp := &api.PumpResponse{}
+
+	batchSize := streamBatchSize
+	if queueSize := s.p.QueueSize(); queueSize < batchSize {
+		batchSize = queueSize
+	}
+
+	var (
+		ids  []int64
+		msgs []pumper.Message
+	)
+	flush := func() error {
+		if len(msgs) == 0 {
+			return nil
+		}
+
+		offset := resp.Received - int64(len(msgs))
+		res, err := s.p.PumpBatchWait(stream.Context(), msgs)
+		if err != nil {
+			return pumpStatus(err)
+		}
+
+		resp.Sent += int64(res.Sent)
+		resp.Spooled += int64(res.Spooled)
+		for _, e := range res.Errors {
+			s.receivedErr.WithLabelValues(ip).Inc()
+			resp.Errors = append(resp.Errors, &api.PumpError{
+				Index: offset + int64(e.Index),
+				Id:    ids[e.Index],
+				Error: e.Error,
+			})
+		}
+		if res.Pending > 0 {
+			resp.Errors = append(resp.Errors, &api.PumpError{
+				Index: offset,
+				Error: fmt.Sprintf("%d users of the batch not acknowledged in time", res.Pending),
+			})
+		}
+
+		ids, msgs = ids[:0], msgs[:0]
+		return nil
+	}
+
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			if err := flush(); err != nil {
+				return err
+			}
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			s.receivedErr.WithLabelValues(ip).Inc()
+			return err
+		}
+
+		resp.Received++
+		s.received.WithLabelValues(ip).Inc()
+
+		msg, err := message(user)
+		if err != nil {
+			s.receivedErr.WithLabelValues(ip).Inc()
+			resp.Errors = append(resp.Errors, &api.PumpError{
+				Index: resp.Received - 1,
+				Id:    user.GetId(),
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		ids = append(ids, user.GetId())
+		msgs = append(msgs, msg)
+		if len(msgs) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pumpStatus converts the error of the pumper into the gRPC status.
+func pumpStatus(err error) error {
+	switch err {
+	case pumper.ErrQueueFull, pumper.ErrBatchTooLarge:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case pumper.ErrClosed:
+		return status.Error(codes.Unavailable, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func message(user *api.User) (pumper.Message, error) {
+	data, err := json.Marshal(user.ToModel())
+	if err != nil {
+		return pumper.Message{}, fmt.Errorf("can't serialize user: %w", err)
+	}
+
+	return pumper.Message{Key: fmt.Sprintf("%d", user.GetId()), Value: data}, nil
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	return p.Addr.String()
+}
+
+// ServeGRPC starts gRPC server in background. Returned server should be stopped by the caller.
+func ServeGRPC(pumper *pumper.Pumper, port int) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on port %d: %w", port, err)
+	}
+
+	srv := grpc.NewServer()
+	api.RegisterIngestionServer(srv, NewGRPCServer(pumper))
+
+	go func() {
+		log.Info().Msgf("gRPC Server started at port: %d", port)
+		if err := srv.Serve(lis); err != nil {
+			log.Fatal().Err(err).Msg("gRPC server crashed")
+		}
+	}()
+
+	return srv, nil
+}
@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
 
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/pumper"
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/uploads"
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/version"
 	"github.com/mateuszdyminski/am-pipeline/models"
+
+	"github.com/gorilla/mux"
 )
 
 func (s *Server) pumpUsers(w http.ResponseWriter, r *http.Request) {
@@ -29,6 +35,7 @@ func (s *Server) pumpUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	msgs := make([]pumper.Message, 0, len(users))
 	for _, user := range users {
 		data, err := json.Marshal(user)
 		if err != nil {
@@ -38,16 +45,118 @@ func (s *Server) pumpUsers(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.received.WithLabelValues(ip).Inc()
+		msgs = append(msgs, pumper.Message{Key: fmt.Sprintf("%d", user.Pnum), Value: data})
+	}
 
-		if err := s.p.Pump(fmt.Sprintf("%d", user.Pnum), data); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
-		}
+	res, err := s.p.PumpBatch(r.Context(), msgs)
+	switch err {
+	case nil:
+	case pumper.ErrQueueFull:
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		s.receivedErr.WithLabelValues(ip).Add(float64(len(users)))
+		return
+	case pumper.ErrBatchTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(err.Error()))
+		s.receivedErr.WithLabelValues(ip).Add(float64(len(users)))
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		s.receivedErr.WithLabelValues(ip).Add(float64(len(users)))
+		return
+	}
+
+	s.received.WithLabelValues(ip).Add(float64(len(users)))
+
+	d, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// users which weren't acknowledged in time are accepted, but their delivery is not confirmed
+	status := http.StatusOK
+	if res.Failed > 0 {
+		status = http.StatusInternalServerError
+	} else if res.Pending > 0 {
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(d)
+}
+
+func (s *Server) uploadUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("can't parse multipart form! err: %s", err.Error())))
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("can't read file from form! err: %s", err.Error())))
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(header.Filename), ".")
 	}
 
+	f, err := uploads.ParseFormat(format)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := s.uploads.Start(header.Filename, f, file)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("can't start upload! err: %s", err.Error())))
+		return
+	}
+
+	d, err := json.Marshal(job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/uploads/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(d)
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.uploads.Get(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("upload not found!"))
+		return
+	}
+
+	d, err := json.Marshal(job)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
+	w.Write(d)
 }
 
 func (s *Server) version(w http.ResponseWriter, r *http.Request) {
@@ -82,10 +191,10 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
-	if atomic.LoadInt32(&ready) == 1 {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+	if atomic.LoadInt32(&ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	w.WriteHeader(http.StatusServiceUnavailable)
+
+	s.checks.ServeHTTP(w, r)
 }
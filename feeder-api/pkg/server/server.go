@@ -10,11 +10,14 @@ import (
 
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/config"
 	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/pumper"
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/uploads"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -22,42 +25,35 @@ var (
 	ready   int32 = 1
 )
 
+// DefaultMaxUploadMB is used when MaxUploadMB is not set in config.
+const DefaultMaxUploadMB = 100
+
 type Server struct {
-	mux         *mux.Router
-	p           *pumper.Pumper
-	received    *prometheus.CounterVec
-	receivedErr *prometheus.CounterVec
+	mux           *mux.Router
+	p             *pumper.Pumper
+	checks        *checks.Checker
+	uploads       *uploads.Manager
+	maxUploadSize int64
+	received      *prometheus.CounterVec
+	receivedErr   *prometheus.CounterVec
 }
 
-func NewServer(cfg *config.Config, pumper *pumper.Pumper, options ...func(*Server)) *Server {
-	received := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "am",
-			Subsystem: "feeder_api",
-			Name:      "received_total",
-			Help:      "The total number of received users.",
-		},
-		[]string{"source_ip"},
-	)
+func NewServer(cfg *config.Config, pumper *pumper.Pumper, checker *checks.Checker, options ...func(*Server)) *Server {
+	received, receivedErr := receivedMetrics()
 
-	receivedErr := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "am",
-			Subsystem: "feeder_api",
-			Name:      "received_total_err",
-			Help:      "The total number of errors during receiving users.",
-		},
-		[]string{"source_ip"},
-	)
-
-	prometheus.Register(received)
-	prometheus.Register(receivedErr)
+	maxUploadMB := cfg.MaxUploadMB
+	if maxUploadMB <= 0 {
+		maxUploadMB = DefaultMaxUploadMB
+	}
 
 	s := &Server{
-		p:           pumper,
-		mux:         mux.NewRouter(),
-		received:    received,
-		receivedErr: receivedErr,
+		p:             pumper,
+		checks:        checker,
+		uploads:       uploads.NewManager(pumper),
+		maxUploadSize: int64(maxUploadMB) << 20,
+		mux:           mux.NewRouter(),
+		received:      received,
+		receivedErr:   receivedErr,
 	}
 
 	for _, f := range options {
@@ -67,6 +63,10 @@ func NewServer(cfg *config.Config, pumper *pumper.Pumper, options ...func(*Serve
 	// users handlers
 	s.mux.HandleFunc("/users", s.pumpUsers).Methods("POST")
 
+	// uploads handlers
+	s.mux.HandleFunc("/uploads", s.uploadUsers).Methods("POST")
+	s.mux.HandleFunc("/uploads/{id}", s.upload).Methods("GET")
+
 	// general handlers
 	s.mux.HandleFunc("/health", s.health)
 	s.mux.HandleFunc("/ready", s.ready)
@@ -84,12 +84,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func ListenAndServe(pumper *pumper.Pumper, cfg *config.Config, cancelCtx context.Context) {
+func ListenAndServe(pumper *pumper.Pumper, checker *checks.Checker, cfg *config.Config, cancelCtx context.Context) {
 	inst := NewInstrument()
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.HTTPPort),
-		Handler:      inst.Wrap(NewServer(cfg, pumper)),
-		ReadTimeout:  5 * time.Second,
+		Handler:      inst.Wrap(NewServer(cfg, pumper, checker)),
+		ReadTimeout:  1 * time.Minute,
 		WriteTimeout: 1 * time.Minute,
 		IdleTimeout:  15 * time.Second,
 	}
@@ -102,6 +102,15 @@ func ListenAndServe(pumper *pumper.Pumper, cfg *config.Config, cancelCtx context
 		}
 	}()
 
+	var grpcSrv *grpc.Server
+	if cfg.GRPCPort > 0 {
+		var err error
+		grpcSrv, err = ServeGRPC(pumper, cfg.GRPCPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't start gRPC server")
+		}
+	}
+
 	// wait for SIGTERM or SIGINT
 	<-cancelCtx.Done()
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
@@ -115,6 +124,11 @@ func ListenAndServe(pumper *pumper.Pumper, cfg *config.Config, cancelCtx context
 
 	log.Info().Msgf("Shutting down HTTP server with timeout: %v", time.Duration(3)*time.Second)
 
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+		log.Info().Msg("gRPC server stopped")
+	}
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("HTTP server graceful shutdown failed")
 	} else {
@@ -122,6 +136,42 @@ func ListenAndServe(pumper *pumper.Pumper, cfg *config.Config, cancelCtx context
 	}
 }
 
+// receivedMetrics returns counters of received users shared by HTTP and gRPC servers.
+func receivedMetrics() (*prometheus.CounterVec, *prometheus.CounterVec) {
+	received := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "feeder_api",
+			Name:      "received_total",
+			Help:      "The total number of received users.",
+		},
+		[]string{"source_ip"},
+	)
+
+	receivedErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "feeder_api",
+			Name:      "received_total_err",
+			Help:      "The total number of errors during receiving users.",
+		},
+		[]string{"source_ip"},
+	)
+
+	return registerCounterVec(received), registerCounterVec(receivedErr)
+}
+
+// registerCounterVec registers counter or returns the one registered before.
+func registerCounterVec(c *prometheus.CounterVec) *prometheus.CounterVec {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector.(*prometheus.CounterVec)
+		}
+	}
+
+	return c
+}
+
 func userIP(r *http.Request) string {
 	ip := r.Header.Get("X-Real-Ip")
 	if ip == "" {
@@ -0,0 +1,103 @@
+package uploads
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// Format describes the type of the uploaded file.
+type Format string
+
+const (
+	// FormatCSV is the pipe separated format consumed by the feeder.
+	FormatCSV Format = "csv"
+	// FormatNDJSON is a file with one JSON encoded user per line.
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat converts string into Format.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return FormatCSV, nil
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	}
+
+	return "", fmt.Errorf("unsupported format: %s", s)
+}
+
+// Record holds single parsed line of the uploaded file.
+type Record struct {
+	Line int
+	User models.User
+	Err  error
+}
+
+// streamUsers parses users from reader in given format.
+func streamUsers(r io.Reader, format Format) chan Record {
+	if format == FormatNDJSON {
+		return streamNDJSONUsers(r)
+	}
+
+	return streamCsvUsers(r)
+}
+
+// streamCsvUsers parses users with the same rules as the feeder does.
+func streamCsvUsers(reader io.Reader) chan Record {
+	out := make(chan Record, 1024)
+	go func() {
+		defer close(out)
+
+		last := 0
+		err := models.ParseUsersCSV(reader, func(line int, u models.User, err error) error {
+			last = line
+			out <- Record{Line: line, User: u, Err: err}
+			return nil
+		})
+		if err != nil {
+			out <- Record{Line: last + 1, Err: fmt.Errorf("can't read file: %w", err)}
+		}
+	}()
+
+	return out
+}
+
+// streamNDJSONUsers parses users encoded as JSON, one per line.
+func streamNDJSONUsers(reader io.Reader) chan Record {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	out := make(chan Record, 1024)
+	go func() {
+		defer close(out)
+
+		i := 0
+		for scanner.Scan() {
+			i++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var u models.User
+			if err := json.Unmarshal([]byte(line), &u); err != nil {
+				out <- Record{Line: i, Err: fmt.Errorf("can't deserialize user: %w", err)}
+				continue
+			}
+
+			out <- Record{Line: i, User: u}
+		}
+
+		if err := scanner.Err(); err != nil {
+			out <- Record{Line: i + 1, Err: fmt.Errorf("can't read file: %w", err)}
+		}
+	}()
+
+	return out
+}
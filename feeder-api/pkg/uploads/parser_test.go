@@ -0,0 +1,136 @@
+package uploads
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	csvUser1 = "37322847|-74.046736|41.120211|test1@test.com|95256|173|Guatechapin04|1|Spring Valley|Busco mujer|2|1968-10-04"
+	csvUser2 = "37322846|-9.38956|38.70943|test2@test.com|74000|175|ghghs|29|Cascais|dsd|2|1993-07-05"
+)
+
+// parsed is the outcome of the single record - pnum of the user or the error.
+type parsed struct {
+	line int
+	pnum int64
+	err  string
+}
+
+func collect(records chan Record) []parsed {
+	var out []parsed
+	for rec := range records {
+		p := parsed{line: rec.Line, pnum: rec.User.Pnum}
+		if rec.Err != nil {
+			p = parsed{line: rec.Line, err: rec.Err.Error()}
+		}
+		out = append(out, p)
+	}
+
+	return out
+}
+
+func TestStreamUsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		file   string
+		want   []parsed
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			file:   csvUser1 + "\n" + csvUser2 + "\n",
+			want:   []parsed{{line: 1, pnum: 37322847}, {line: 2, pnum: 37322846}},
+		},
+		{
+			name:   "csv without trailing new line",
+			format: FormatCSV,
+			file:   csvUser1,
+			want:   []parsed{{line: 1, pnum: 37322847}},
+		},
+		{
+			name:   "csv with wrong number of fields",
+			format: FormatCSV,
+			file:   "1|2|3\n" + csvUser2 + "\n",
+			want:   []parsed{{line: 1, err: "wrong number of parsed fields: 3"}, {line: 2, pnum: 37322846}},
+		},
+		{
+			name:   "csv with invalid field",
+			format: FormatCSV,
+			file:   strings.Replace(csvUser1, "|173|", "|tall|", 1) + "\n" + csvUser2 + "\n",
+			want:   []parsed{{line: 1, err: "can't deserialize height. Val: tall"}, {line: 2, pnum: 37322846}},
+		},
+		{
+			name:   "csv with blank lines",
+			format: FormatCSV,
+			file:   "\n" + csvUser1 + "\n\n\n" + csvUser2 + "\n",
+			want:   []parsed{{line: 2, pnum: 37322847}, {line: 5, pnum: 37322846}},
+		},
+		{
+			name:   "csv with quoted field spanning lines",
+			format: FormatCSV,
+			file:   strings.Replace(csvUser1, "|Busco mujer|", "|\"Busco\r\nmujer\n\"|", 1) + "\n" + csvUser2 + "\n1|2\n",
+			want: []parsed{
+				{line: 1, pnum: 37322847},
+				{line: 4, pnum: 37322846},
+				{line: 5, err: "wrong number of parsed fields: 2"},
+			},
+		},
+		{
+			name:   "empty csv",
+			format: FormatCSV,
+		},
+		{
+			name:   "ndjson",
+			format: FormatNDJSON,
+			file:   `{"id": 1}` + "\n" + `{"id": 2}` + "\n",
+			want:   []parsed{{line: 1, pnum: 1}, {line: 2, pnum: 2}},
+		},
+		{
+			name:   "ndjson with blank and invalid lines",
+			format: FormatNDJSON,
+			file:   `{"id": 1}` + "\n\n" + `{"id": ` + "\n" + `{"id": 4}`,
+			want: []parsed{
+				{line: 1, pnum: 1},
+				{line: 3, err: "can't deserialize user: unexpected end of JSON input"},
+				{line: 4, pnum: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(streamUsers(strings.NewReader(tt.file), tt.format))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d records %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("record %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    Format
+		wantErr bool
+	}{
+		{format: "csv", want: FormatCSV},
+		{format: "CSV", want: FormatCSV},
+		{format: "ndjson", want: FormatNDJSON},
+		{format: "jsonl", want: FormatNDJSON},
+		{format: "parquet", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.format)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFormat(%q): got %q, %v, want %q", tt.format, got, err, tt.want)
+		}
+	}
+}
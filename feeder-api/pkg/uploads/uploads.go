@@ -0,0 +1,281 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/feeder-api/pkg/pumper"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status describes the state of the upload job.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// MaxLineErrors limits the number of per-line errors kept for single job.
+const MaxLineErrors = 1000
+
+// batchSize is the number of users sent to Kafka together.
+const batchSize = 500
+
+// jobRetention says how long finished jobs are kept in memory.
+const jobRetention = time.Hour
+
+// LineError holds info why particular line was rejected.
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Job holds progress of the single upload.
+type Job struct {
+	ID          string      `json:"id"`
+	Filename    string      `json:"filename"`
+	Format      Format      `json:"format"`
+	Status      Status      `json:"status"`
+	Read        int         `json:"read"`
+	Sent        int         `json:"sent"`
+	Spooled     int         `json:"spooled"`
+	Failed      int         `json:"failed"`
+	Errors      []LineError `json:"errors,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	CompletedAt *time.Time  `json:"completedAt,omitempty"`
+}
+
+// Manager runs upload jobs in background and tracks their progress.
+type Manager struct {
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	p       *pumper.Pumper
+	records *prometheus.CounterVec
+	errs    *prometheus.CounterVec
+}
+
+// NewManager creates new Manager.
+func NewManager(p *pumper.Pumper) *Manager {
+	records := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "feeder_api",
+			Name:      "upload_records_total",
+			Help:      "The total number of users read from uploaded files.",
+		},
+		[]string{"format"},
+	)
+
+	errs := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "feeder_api",
+			Name:      "upload_records_total_err",
+			Help:      "The total number of rejected lines of uploaded files.",
+		},
+		[]string{"format"},
+	)
+
+	prometheus.Register(records)
+	prometheus.Register(errs)
+
+	return &Manager{
+		jobs:    make(map[string]*Job),
+		p:       p,
+		records: records,
+		errs:    errs,
+	}
+}
+
+// Start stores the file on disk and starts pumping its content in background.
+func (m *Manager) Start(filename string, format Format, file io.Reader) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile("", "upload-"+id+"-")
+	if err != nil {
+		return nil, fmt.Errorf("can't create temporary file: %w", err)
+	}
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("can't store uploaded file: %w", err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("can't rewind uploaded file: %w", err)
+	}
+
+	job := &Job{
+		ID:        id,
+		Filename:  filename,
+		Format:    format,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.evict()
+	m.jobs[id] = job
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+
+		m.run(job, tmp)
+	}()
+
+	return m.snapshot(job), nil
+}
+
+// Get returns copy of the job with given id.
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	job, ok := m.jobs[id]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	return m.snapshot(job), true
+}
+
+func (m *Manager) run(job *Job, r io.Reader) {
+	m.update(job, func(j *Job) { j.Status = StatusRunning })
+	log.Infof("upload[%s] started. File: %s", job.ID, job.Filename)
+
+	format := string(job.Format)
+	lines := make([]int, 0, batchSize)
+	msgs := make([]pumper.Message, 0, batchSize)
+	for rec := range streamUsers(r, job.Format) {
+		if rec.Err != nil {
+			m.errs.WithLabelValues(format).Inc()
+			m.update(job, func(j *Job) { j.fail(rec.Line, rec.Err) })
+			continue
+		}
+
+		m.records.WithLabelValues(format).Inc()
+		m.update(job, func(j *Job) { j.Read++ })
+
+		data, err := json.Marshal(rec.User)
+		if err != nil {
+			m.update(job, func(j *Job) { j.fail(rec.Line, err) })
+			continue
+		}
+
+		lines = append(lines, rec.Line)
+		msgs = append(msgs, pumper.Message{Key: fmt.Sprintf("%d", rec.User.Pnum), Value: data})
+		if len(msgs) == batchSize {
+			m.pump(job, lines, msgs)
+			lines, msgs = lines[:0], msgs[:0]
+		}
+	}
+
+	if len(msgs) > 0 {
+		m.pump(job, lines, msgs)
+	}
+
+	m.update(job, func(j *Job) {
+		now := time.Now()
+		j.CompletedAt = &now
+		j.Status = StatusCompleted
+		if j.Sent == 0 && j.Spooled == 0 && j.Failed > 0 {
+			j.Status = StatusFailed
+			j.Error = "no users sent"
+		}
+	})
+
+	done := m.snapshot(job)
+	log.Infof("upload[%s] finished. Sent: %d, failed: %d", done.ID, done.Sent, done.Failed)
+}
+
+// pump sends batch of users waiting for the room in the queue when it's saturated.
+func (m *Manager) pump(job *Job, lines []int, msgs []pumper.Message) {
+	res, err := m.p.PumpBatchWait(context.Background(), msgs)
+	if err != nil {
+		m.update(job, func(j *Job) {
+			for _, line := range lines {
+				j.fail(line, fmt.Errorf("can't send user: %w", err))
+			}
+		})
+		return
+	}
+
+	m.update(job, func(j *Job) {
+		j.Sent += res.Sent
+		j.Spooled += res.Spooled
+		for _, e := range res.Errors {
+			j.fail(lines[e.Index], fmt.Errorf("can't send user: %s", e.Error))
+		}
+
+		if res.Pending > 0 {
+			j.Failed += res.Pending
+			j.Errors = append(j.Errors, LineError{
+				Line:  lines[0],
+				Error: fmt.Sprintf("%d users of the batch not acknowledged in time", res.Pending),
+			})
+		}
+	})
+}
+
+func (m *Manager) update(job *Job, fn func(*Job)) {
+	m.mu.Lock()
+	fn(job)
+	m.mu.Unlock()
+}
+
+func (m *Manager) snapshot(job *Job) *Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cp := *job
+	cp.Errors = append([]LineError(nil), job.Errors...)
+	return &cp
+}
+
+// evict removes finished jobs older than retention period. Must be called with lock held.
+func (m *Manager) evict() {
+	for id, job := range m.jobs {
+		if job.CompletedAt != nil && time.Since(*job.CompletedAt) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *Job) fail(line int, err error) {
+	j.Failed++
+	if len(j.Errors) < MaxLineErrors {
+		j.Errors = append(j.Errors, LineError{Line: line, Error: err.Error()})
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't generate job id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -10,3 +10,5 @@ require (
 	github.com/prometheus/client_golang v1.1.0 // indirect
 	github.com/sirupsen/logrus v1.4.2
 )
+
+replace github.com/mateuszdyminski/am-pipeline/models => ../models
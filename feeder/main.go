@@ -1,17 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/mateuszdyminski/am-pipeline/models"
+	"github.com/mateuszdyminski/am-pipeline/models/kafkaconfig"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Shopify/sarama"
@@ -31,6 +29,9 @@ type Config struct {
 	CsvPath            string
 	SourceDataType     string
 	PushgatewayAddress string
+
+	// Kafka holds TLS and SASL settings of the connection to brokers
+	Kafka kafkaconfig.Config
 }
 
 func init() {
@@ -56,7 +57,7 @@ func main() {
 	}
 
 	feeder, err := NewFeeder(conf)
-	if err := toml.Unmarshal(bytes, &conf); err != nil {
+	if err != nil {
 		log.Fatal("can't create feeder!", err)
 	}
 
@@ -134,6 +135,9 @@ func NewFeeder(cfg Config) (*Feeder, error) {
 	config.Producer.Retry.Max = 10
 	config.Producer.Return.Successes = true
 	config.Producer.Partitioner = sarama.NewRandomPartitioner
+	if err := cfg.Kafka.Apply(config); err != nil {
+		return nil, err
+	}
 	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
 	if err != nil {
 		return nil, err
@@ -244,96 +248,30 @@ func (f *Feeder) streamCsvUsers() chan models.User {
 	if err != nil {
 		log.Fatal("can't file with users:", err)
 	}
-	defer file.Close()
 
 	log.Infof("Start reading CSV file!")
 
-	r := csv.NewReader(bufio.NewReader(file))
-	r.Comma = '|'
-	r.LazyQuotes = true
-	records, err := r.ReadAll()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Infof("Read %d lines!", len(records))
-
 	out := make(chan models.User, 1024)
 	go func() {
-		for i, line := range records {
-			if len(line) != 12 {
-				log.Errorf("wrong number of parsed fields: %d. Index %d", len(line), i)
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-			u := models.User{}
-			log.Infof("read user record: %s", line)
+		defer file.Close()
 
-			var err error
-			u.Pnum, err = strconv.ParseInt(line[0], 10, 64)
+		err := models.ParseUsersCSV(file, func(line int, u models.User, err error) error {
 			if err != nil {
-				log.Errorf("can't deserialize pnum. Val: %s", line[0])
+				log.Errorf("can't read user. Line: %d. Err: %v", line, err)
 				f.readErr.WithLabelValues("csv").Inc()
-				continue
+				return nil
 			}
 
-			long, err := strconv.ParseFloat(line[1], 64)
-			if err != nil {
-				log.Errorf("can't deserialize longitude. Val: %s. Line: %d", line[1], i)
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
+			if u.Location.Longitude == 0 || u.Location.Latitude == 0 {
+				log.Warningf("at least one value of location could be wrong. Vals long, %f, lat: %f", u.Location.Longitude, u.Location.Latitude)
 			}
 
-			lat, err := strconv.ParseFloat(line[2], 64)
-			if err != nil {
-				log.Errorf("can't deserialize latitude. Val: %s. Line: %d", line[2], i)
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-
-			u.Location = &models.Location{long, lat}
-			if long == 0 || lat == 0 {
-				log.Warningf("at least one value of location could be wrong. Vals long, %d, lat: %d", long, lat)
-			}
-
-			u.Email = &line[3]
-			weight, err := strconv.Atoi(line[4])
-			if err != nil {
-				log.Errorf("can't deserialize weight. Val: %s", line[4])
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-			u.Weight = &weight
-
-			height, err := strconv.Atoi(line[5])
-			if err != nil {
-				log.Errorf("can't deserialize height. Val: %s", line[5])
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-			u.Height = &height
-
-			u.Nickname = &line[6]
-			u.Country, err = strconv.Atoi(line[7])
-			if err != nil {
-				log.Errorf("can't deserialize country: Val: %s", line[7])
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-			u.City = &line[8]
-			u.Caption = &line[9]
-			gender, err := strconv.Atoi(line[10])
-			if err != nil {
-				log.Errorf("can't deserialize gender. Val: %s", line[10])
-				f.readErr.WithLabelValues("csv").Inc()
-				continue
-			}
-			u.Gender = &gender
-			u.Dob = &line[11]
-
 			out <- u
 			f.read.WithLabelValues("csv").Inc()
-			continue
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
 		}
 
 		log.Infof("All users sent. Closing channel")
@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/Shopify/sarama v1.23.1
+	github.com/blevesearch/bleve v1.0.14
 	github.com/gorilla/mux v1.7.3
 	github.com/mateuszdyminski/am-pipeline/models v0.0.0-20190919094627-bec8d1e2eafe
 	github.com/olivere/elastic/v7 v7.0.6
@@ -12,3 +13,5 @@ require (
 	github.com/rs/zerolog v1.15.0
 	github.com/sirupsen/logrus v1.4.2
 )
+
+replace github.com/mateuszdyminski/am-pipeline/models => ../models
@@ -2,19 +2,33 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/server"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/signals"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultShutdownTimeout is used when ShutdownTimeoutMs is not set in config.
+const DefaultShutdownTimeout = 30 * time.Second
+
 var configPath string
 
 func init() {
 	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nCommands:\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "  index    consume users from Kafka and index them (default)")
+		fmt.Fprintln(flag.CommandLine.Output(), "  replay   re-inject messages from the dead-letter topic into their source topics")
+		fmt.Fprintln(flag.CommandLine.Output(), "  reindex  build new version of the index and swap aliases to it (see 'reindex -h')")
+		fmt.Fprintln(flag.CommandLine.Output(), "  snapshot export the index to gzipped NDJSON file with checksum (see 'snapshot -h')")
+		fmt.Fprintln(flag.CommandLine.Output(), "  restore  index the snapshot into the new index (see 'restore -h')")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
 		flag.PrintDefaults()
 	}
 
@@ -30,12 +44,107 @@ func main() {
 		log.Fatal("can't load config file", err)
 	}
 
+	switch flag.Arg(0) {
+	case "", "index":
+		index(cfg)
+	case "replay":
+		replayed, err := indexer.Replay(cfg)
+		if err != nil {
+			log.Fatalf("can't replay dead-letter topic. Replayed: %d. Err: %v", replayed, err)
+		}
+		log.Infof("replayed %d messages from dead-letter topic", replayed)
+	case "reindex":
+		reindex(cfg, flag.Args()[1:])
+	case "snapshot":
+		snapshot(cfg, flag.Args()[1:])
+	case "restore":
+		restore(cfg, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func index(cfg *config.Config) {
 	ctx := signals.SetupSignalContext()
 	indexer, err := indexer.NewIndexer(cfg)
 	if err != nil {
 		log.Fatal("can't create indexer", err)
 	}
-	go indexer.Index()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := indexer.Index(ctx); err != nil {
+			log.Fatalf("can't index users. Err: %v", err)
+		}
+	}()
+
+	checker := checks.New(checks.DefaultTimeout, checks.DefaultCacheTTL)
+	checker.Register("kafka", indexer.CheckKafka)
+	for _, s := range indexer.Sinks() {
+		checker.Register(s.Name(), s.Health)
+	}
+	checker.Register("consumer-group", indexer.CheckConsumerGroup)
+
+	options := []func(*server.Server){server.WithStatus(indexer)}
+	if cfg.AdminToken != "" {
+		options = append(options, server.WithAdmin(indexer, cfg.AdminToken))
+	}
+
+	timeout := time.Duration(cfg.ShutdownTimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	go func() {
+		<-ctx.Done()
+		time.Sleep(timeout)
+		log.Fatalf("indexer didn't stop within %v", timeout)
+	}()
+
+	server.ListenAndServe(cfg, checker, ctx, options...)
+	<-done
+	log.Info("indexer stopped")
+}
+
+func reindex(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	from := fs.String("from", indexer.ReindexFromIndex, "source of documents: 'index' or 'kafka'")
+	version := fs.Int("version", cfg.IndexVersion, "version of the new physical index")
+	fs.Parse(args)
 
-	server.ListenAndServe(cfg, ctx)
+	if err := indexer.Reindex(cfg, *from, *version); err != nil {
+		log.Fatalf("can't reindex. Err: %v", err)
+	}
+	log.Info("reindex finished")
+}
+
+func snapshot(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	index := fs.String("index", "", "index or alias to export (default read alias)")
+	file := fs.String("file", "", "snapshot file (default <index>-<time>.ndjson.gz)")
+	fs.Parse(args)
+
+	if _, err := indexer.Snapshot(cfg, *index, *file); err != nil {
+		log.Fatalf("can't take snapshot. Err: %v", err)
+	}
+	log.Info("snapshot finished")
+}
+
+func restore(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := fs.String("file", "", "snapshot file")
+	index := fs.String("index", "", "new index to restore into (default physical index of IndexVersion)")
+	swap := fs.Bool("swap", false, "point read and write aliases to the restored index")
+	fs.Parse(args)
+
+	if *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	restored, err := indexer.Restore(cfg, *file, *index, *swap)
+	if err != nil {
+		log.Fatalf("can't restore snapshot. Restored: %d. Err: %v", restored, err)
+	}
+	log.Info("restore finished")
 }
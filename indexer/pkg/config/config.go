@@ -4,18 +4,99 @@ import (
 	"io/ioutil"
 
 	"github.com/BurntSushi/toml"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+	"github.com/mateuszdyminski/am-pipeline/models/kafkaconfig"
 )
 
 // Config holds configuration of feeder.
 type Config struct {
 	Brokers        []string
 	Topic          string
+	DLQTopic       string
 	HTTPPort       int
 	ReadFromOldest bool
 
-	Elastics        []string
-	ElasticUser     string
-	ElasticPassword string
+	// ShutdownTimeoutMs is the time to flush users and commit offsets after SIGTERM
+	ShutdownTimeoutMs int
+
+	// Consumer config
+	ConsumerGroup       string
+	Topics              []string
+	TopicPattern        string
+	RebalanceStrategy   string
+	IsolationLevel      string
+	SessionTimeoutMs    int
+	HeartbeatIntervalMs int
+	FetchMinBytes       int32
+	FetchDefaultBytes   int32
+	FetchMaxBytes       int32
+	FetchMaxWaitMs      int
+
+	// AdminToken enables the admin API authorized with given bearer token
+	AdminToken string
+
+	// Kafka holds TLS and SASL settings of the connection to brokers
+	Kafka kafkaconfig.Config
+
+	// Elastic is the connection to the Elasticsearch cluster
+	Elastic esclient.Config
+
+	// Sinks which users are written to: elasticsearch, opensearch, file and bleve. Elasticsearch by default
+	Sinks []string
+
+	// OpenSearch sink config
+	OpenSearch      esclient.Config
+	OpenSearchIndex string
+
+	// File sink config
+	ArchiveDir      string
+	ArchiveMaxBytes int64
+	ArchiveGzip     bool
+
+	// Bleve sink config
+	BlevePath string
+
+	// Index config
+	IndexAlias     string
+	WriteAlias     string
+	IndexVersion   int
+	ReindexOnDrift bool
+
+	// IndexRoutes pick the index of the user by the first matching rule
+	IndexRoutes []IndexRoute
+
+	// Versioning config
+	VersionSource string
+	VersionField  string
+
+	// Enrichment config
+	Enrichers        []string
+	GeohashPrecision int
+
+	// Reverse geocoding config
+	GazetteerFile        string
+	GazetteerAdmin1File  string
+	GeocodeMaxDistanceKm float64
+
+	// Bulk indexing config
+	BulkMaxActions      int
+	BulkMaxBytes        int64
+	BulkFlushIntervalMs int
+	BulkWorkers         int
+	BulkMaxRetries      int
+
+	// Workers config. BulkWorkers is the number of workers
+	WorkerQueueSize int
+	WorkerRouting   string
+}
+
+// IndexRoute sends users to the index when the field of the user or the header of Kafka message has one of the values.
+// Index may contain {value} replaced with the matched value and {month} replaced with the month of the message.
+type IndexRoute struct {
+	Field  string
+	Header string
+	Values []string
+	Index  string
 }
 
 // LoadConfig loads config from env vars.
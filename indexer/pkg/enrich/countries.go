@@ -0,0 +1,33 @@
+package enrich
+
+type country struct {
+	iso  string
+	name string
+}
+
+// countries maps numeric country ids of the original dataset to ISO 3166-1 codes.
+var countries = map[int]country{
+	1:  {"US", "United States"},
+	2:  {"CA", "Canada"},
+	3:  {"GB", "United Kingdom"},
+	5:  {"AU", "Australia"},
+	7:  {"DE", "Germany"},
+	11: {"ES", "Spain"},
+	13: {"FI", "Finland"},
+	14: {"NO", "Norway"},
+	15: {"BR", "Brazil"},
+	17: {"MX", "Mexico"},
+	19: {"AR", "Argentina"},
+	20: {"CO", "Colombia"},
+	21: {"CL", "Chile"},
+	23: {"ZA", "South Africa"},
+	24: {"FR", "France"},
+	27: {"JP", "Japan"},
+	29: {"PT", "Portugal"},
+	30: {"HK", "Hong Kong"},
+	31: {"TW", "Taiwan"},
+	36: {"KR", "South Korea"},
+	40: {"HU", "Hungary"},
+	43: {"CN", "China"},
+	47: {"RU", "Russia"},
+}
@@ -0,0 +1,228 @@
+package enrich
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// Names of the available enrichers.
+const (
+	Age     = "age"
+	BMI     = "bmi"
+	Country = "country"
+	Geohash = "geohash"
+	Email   = "email"
+	Geocode = "geocode"
+)
+
+// DefaultGeohashPrecision is used when GeohashPrecision is not set in config.
+const DefaultGeohashPrecision = 7
+
+// Enricher derives fields of the user before it's indexed.
+type Enricher interface {
+	Name() string
+	Enrich(user *models.User) error
+}
+
+// EnricherFunc allows to use ordinary function as Enricher.
+type EnricherFunc struct {
+	name string
+	fn   func(user *models.User) error
+}
+
+// NewEnricherFunc creates Enricher with given name.
+func NewEnricherFunc(name string, fn func(user *models.User) error) EnricherFunc {
+	return EnricherFunc{name: name, fn: fn}
+}
+
+// Name returns name of the enricher.
+func (e EnricherFunc) Name() string {
+	return e.name
+}
+
+// Enrich calls the function.
+func (e EnricherFunc) Enrich(user *models.User) error {
+	return e.fn(user)
+}
+
+// Chain runs enrichers in order. Failure of one enricher doesn't stop the others.
+type Chain []Enricher
+
+// Options configures enrichers.
+type Options struct {
+	GeohashPrecision int
+
+	// Gazetteer is required by the geocode enricher.
+	Gazetteer            *Gazetteer
+	GeocodeMaxDistanceKm float64
+}
+
+// New creates chain of enrichers with given names. All enrichers are used when names are empty;
+// geocode is added only when the gazetteer is provided.
+func New(names []string, opts Options) (Chain, error) {
+	if len(names) == 0 {
+		names = []string{Age, BMI, Country, Geohash, Email}
+		if opts.Gazetteer != nil {
+			names = append(names, Geocode)
+		}
+	}
+	geohashPrecision := opts.GeohashPrecision
+	if geohashPrecision <= 0 {
+		geohashPrecision = DefaultGeohashPrecision
+	}
+
+	var chain Chain
+	for _, name := range names {
+		switch name {
+		case Age:
+			chain = append(chain, NewEnricherFunc(Age, func(u *models.User) error { return enrichAge(u, time.Now()) }))
+		case BMI:
+			chain = append(chain, NewEnricherFunc(BMI, enrichBMI))
+		case Country:
+			chain = append(chain, NewEnricherFunc(Country, enrichCountry))
+		case Geohash:
+			chain = append(chain, NewEnricherFunc(Geohash, func(u *models.User) error { return enrichGeohash(u, geohashPrecision) }))
+		case Email:
+			chain = append(chain, NewEnricherFunc(Email, enrichEmail))
+		case Geocode:
+			if opts.Gazetteer == nil {
+				return nil, fmt.Errorf("enricher %q requires the gazetteer", name)
+			}
+			chain = append(chain, newGeocoder(opts.Gazetteer, opts.GeocodeMaxDistanceKm))
+		default:
+			return nil, fmt.Errorf("unknown enricher %q", name)
+		}
+	}
+
+	return chain, nil
+}
+
+// Enrich runs all enrichers and returns errors of the failed ones.
+func (c Chain) Enrich(user *models.User) []error {
+	var errs []error
+	for _, e := range c {
+		if err := e.Enrich(user); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+		}
+	}
+
+	return errs
+}
+
+func enrichAge(u *models.User, now time.Time) error {
+	if u.Dob == nil || *u.Dob == "" {
+		return nil
+	}
+
+	dob, err := time.Parse("2006-01-02", *u.Dob)
+	if err != nil {
+		return fmt.Errorf("can't parse dob %q: %w", *u.Dob, err)
+	}
+
+	age := now.Year() - dob.Year()
+	if now.Month() < dob.Month() || (now.Month() == dob.Month() && now.Day() < dob.Day()) {
+		age--
+	}
+	if age < 0 || age > 120 {
+		return fmt.Errorf("age %d out of range", age)
+	}
+
+	bucket := ageBucket(age)
+	u.Age = &age
+	u.AgeBucket = &bucket
+
+	return nil
+}
+
+func ageBucket(age int) string {
+	switch {
+	case age < 18:
+		return "under-18"
+	case age < 25:
+		return "18-24"
+	case age < 35:
+		return "25-34"
+	case age < 45:
+		return "35-44"
+	case age < 55:
+		return "45-54"
+	case age < 65:
+		return "55-64"
+	default:
+		return "65+"
+	}
+}
+
+// enrichBMI computes body mass index. Weight is given in kilograms or, in the original dataset, in grams.
+func enrichBMI(u *models.User) error {
+	if u.Weight == nil || u.Height == nil || *u.Weight <= 0 || *u.Height <= 0 {
+		return nil
+	}
+
+	kg := float64(*u.Weight)
+	if kg > 1000 {
+		kg /= 1000
+	}
+	m := float64(*u.Height) / 100
+
+	bmi := math.Round(kg/(m*m)*10) / 10
+	if bmi < 10 || bmi > 100 {
+		return fmt.Errorf("bmi %.1f out of range", bmi)
+	}
+
+	u.BMI = &bmi
+
+	return nil
+}
+
+func enrichCountry(u *models.User) error {
+	if u.Country == 0 {
+		return nil
+	}
+
+	c, ok := countries[u.Country]
+	if !ok {
+		return nil
+	}
+
+	name, iso := c.name, c.iso
+	u.CountryName = &name
+	u.CountryISO = &iso
+
+	return nil
+}
+
+func enrichGeohash(u *models.User, precision int) error {
+	if u.Location == nil || (u.Location.Latitude == 0 && u.Location.Longitude == 0) {
+		return nil
+	}
+
+	hash := EncodeGeohash(u.Location.Latitude, u.Location.Longitude, precision)
+	u.Geohash = &hash
+
+	return nil
+}
+
+func enrichEmail(u *models.User) error {
+	if u.Email == nil {
+		return nil
+	}
+
+	at := strings.LastIndex(*u.Email, "@")
+	if at < 0 {
+		return nil
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace((*u.Email)[at+1:])), ".")
+	if domain == "" {
+		return nil
+	}
+
+	u.EmailDomain = &domain
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultGeocodeMaxDistanceKm is used when GeocodeMaxDistanceKm is not set in config.
+const DefaultGeocodeMaxDistanceKm = 50
+
+// Place is the populated place from the gazetteer.
+type Place struct {
+	Name       string
+	Names      []string
+	Latitude   float64
+	Longitude  float64
+	Country    string
+	Admin1     string
+	Region     string
+	Population int64
+}
+
+// Gazetteer allows to find places close to given location without any external service.
+type Gazetteer struct {
+	places []Place
+	tree   *kdTree
+}
+
+// LoadGazetteer reads places from the file in GeoNames format (e.g. cities1000.txt from download.geonames.org).
+// Optional admin1 file (admin1CodesASCII.txt) provides names of the regions; otherwise regions are the admin1 codes.
+func LoadGazetteer(path, admin1Path string) (*Gazetteer, error) {
+	regions := make(map[string]string)
+	if admin1Path != "" {
+		f, err := os.Open(admin1Path)
+		if err != nil {
+			return nil, fmt.Errorf("can't open admin1 codes: %w", err)
+		}
+		defer f.Close()
+
+		if regions, err = readAdmin1(f); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open gazetteer: %w", err)
+	}
+	defer f.Close()
+
+	return ReadGazetteer(f, regions)
+}
+
+// ReadGazetteer reads places in GeoNames format. Lines starting with '#' are skipped.
+func ReadGazetteer(r io.Reader, regions map[string]string) (*Gazetteer, error) {
+	g := &Gazetteer{}
+	var points []point
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+		// country code, cc2, admin1 code, admin2 code, admin3 code, admin4 code, population, ...
+		cols := strings.Split(text, "\t")
+		if len(cols) < 15 {
+			return nil, fmt.Errorf("gazetteer line %d: expected at least 15 columns, got %d", line, len(cols))
+		}
+
+		lat, err := strconv.ParseFloat(cols[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: can't parse latitude: %w", line, err)
+		}
+		lon, err := strconv.ParseFloat(cols[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: can't parse longitude: %w", line, err)
+		}
+		population, _ := strconv.ParseInt(cols[14], 10, 64)
+
+		p := Place{
+			Name:       cols[1],
+			Names:      placeNames(cols[1], cols[2], cols[3]),
+			Latitude:   lat,
+			Longitude:  lon,
+			Country:    cols[8],
+			Admin1:     cols[10],
+			Region:     cols[10],
+			Population: population,
+		}
+		if name, ok := regions[p.Country+"."+p.Admin1]; ok {
+			p.Region = name
+		}
+
+		g.places = append(g.places, p)
+		points = append(points, newPoint(lat, lon))
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("can't read gazetteer: %w", err)
+	}
+
+	g.tree = newKDTree(points)
+
+	return g, nil
+}
+
+// Len returns the number of places.
+func (g *Gazetteer) Len() int {
+	return len(g.places)
+}
+
+// Nearest returns the place closest to the location and the distance to it in kilometers.
+func (g *Gazetteer) Nearest(lat, lon float64) (Place, float64, bool) {
+	i, d2 := g.tree.Nearest(newPoint(lat, lon))
+	if i < 0 {
+		return Place{}, 0, false
+	}
+
+	return g.places[i], chordToKm(math.Sqrt(d2)), true
+}
+
+// Within returns places closer to the location than given distance in kilometers.
+func (g *Gazetteer) Within(lat, lon, km float64) []Place {
+	var places []Place
+	g.tree.Within(newPoint(lat, lon), kmToChord(km), func(i int) {
+		places = append(places, g.places[i])
+	})
+
+	return places
+}
+
+// Matches says if the name is one of the names of the place.
+func (p Place) Matches(name string) bool {
+	name = normalizeName(name)
+	for _, n := range p.Names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func placeNames(name, ascii, alternate string) []string {
+	names := []string{normalizeName(name), normalizeName(ascii)}
+	for _, n := range strings.Split(alternate, ",") {
+		if n = normalizeName(n); n != "" {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func readAdmin1(r io.Reader) (map[string]string, error) {
+	regions := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// code (CC.admin1), name, ascii name, geonameid
+		cols := strings.Split(s.Text(), "\t")
+		if len(cols) < 2 {
+			continue
+		}
+		regions[cols[0]] = cols[1]
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("can't read admin1 codes: %w", err)
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,66 @@
+package enrich
+
+import (
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// geocoder fills the city, region and country of the place closest to the user location.
+// Location is flagged as mismatched when the city of the user isn't any of the places nearby
+// or the country of the user differs from the geocoded one.
+type geocoder struct {
+	gazetteer *Gazetteer
+	maxKm     float64
+}
+
+func newGeocoder(g *Gazetteer, maxKm float64) geocoder {
+	if maxKm <= 0 {
+		maxKm = DefaultGeocodeMaxDistanceKm
+	}
+
+	return geocoder{gazetteer: g, maxKm: maxKm}
+}
+
+// Name returns name of the enricher.
+func (g geocoder) Name() string {
+	return Geocode
+}
+
+// Enrich reverse geocodes the location of the user. Users without location or too far from any known place are skipped.
+func (g geocoder) Enrich(u *models.User) error {
+	if u.Location == nil || (u.Location.Latitude == 0 && u.Location.Longitude == 0) {
+		return nil
+	}
+
+	lat, lon := u.Location.Latitude, u.Location.Longitude
+	place, km, ok := g.gazetteer.Nearest(lat, lon)
+	if !ok || km > g.maxKm {
+		return nil
+	}
+
+	city, region, country := place.Name, place.Region, place.Country
+	u.GeoCity = &city
+	u.GeoRegion = &region
+	u.GeoCountry = &country
+
+	mismatch := false
+	if c, ok := countries[u.Country]; ok && c.iso != place.Country {
+		mismatch = true
+	}
+	if u.City != nil && normalizeName(*u.City) != "" && !g.near(*u.City, lat, lon) {
+		mismatch = true
+	}
+	u.LocationMismatch = &mismatch
+
+	return nil
+}
+
+// near says if any place with given name is close to the location.
+func (g geocoder) near(city string, lat, lon float64) bool {
+	for _, p := range g.gazetteer.Within(lat, lon, g.maxKm) {
+		if p.Matches(city) {
+			return true
+		}
+	}
+
+	return false
+}
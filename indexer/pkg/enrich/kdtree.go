@@ -0,0 +1,127 @@
+package enrich
+
+import (
+	"math"
+	"sort"
+)
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0
+
+// point is the location on the unit sphere. Euclidean distance between points grows with
+// the great-circle distance, so the nearest point in 3D is the nearest one on the globe.
+type point [3]float64
+
+func newPoint(lat, lon float64) point {
+	latRad, lonRad := lat*math.Pi/180, lon*math.Pi/180
+	return point{math.Cos(latRad) * math.Cos(lonRad), math.Cos(latRad) * math.Sin(lonRad), math.Sin(latRad)}
+}
+
+func (p point) dist2(q point) float64 {
+	dx, dy, dz := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// chordToKm converts the distance between points to kilometers on the surface.
+func chordToKm(chord float64) float64 {
+	return 2 * earthRadiusKm * math.Asin(math.Min(chord/2, 1))
+}
+
+// kmToChord converts kilometers on the surface to the distance between points.
+func kmToChord(km float64) float64 {
+	return 2 * math.Sin(math.Min(km/(2*earthRadiusKm), math.Pi/2))
+}
+
+type kdNode struct {
+	p           point
+	item        int
+	axis        int
+	left, right *kdNode
+}
+
+// kdTree is the static 3-dimensional tree used for nearest neighbour and radius searches.
+type kdTree struct {
+	root *kdNode
+}
+
+func newKDTree(points []point) *kdTree {
+	items := make([]int, len(points))
+	for i := range items {
+		items[i] = i
+	}
+
+	return &kdTree{root: buildKD(points, items, 0)}
+}
+
+func buildKD(points []point, items []int, depth int) *kdNode {
+	if len(items) == 0 {
+		return nil
+	}
+
+	axis := depth % 3
+	sort.Slice(items, func(i, j int) bool { return points[items[i]][axis] < points[items[j]][axis] })
+
+	mid := len(items) / 2
+	return &kdNode{
+		p:     points[items[mid]],
+		item:  items[mid],
+		axis:  axis,
+		left:  buildKD(points, items[:mid], depth+1),
+		right: buildKD(points, items[mid+1:], depth+1),
+	}
+}
+
+// Nearest returns the item closest to the target and the squared distance to it, or -1 for the empty tree.
+func (t *kdTree) Nearest(target point) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+
+	var search func(n *kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+
+		if d := n.p.dist2(target); d < bestDist {
+			best, bestDist = n.item, d
+		}
+
+		diff := target[n.axis] - n.p[n.axis]
+		near, far := n.left, n.right
+		if diff > 0 {
+			near, far = n.right, n.left
+		}
+
+		search(near)
+		if diff*diff < bestDist {
+			search(far)
+		}
+	}
+	search(t.root)
+
+	return best, bestDist
+}
+
+// Within calls fn for every item closer to the target than the radius.
+func (t *kdTree) Within(target point, radius float64, fn func(item int)) {
+	r2 := radius * radius
+
+	var search func(n *kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+
+		if n.p.dist2(target) <= r2 {
+			fn(n.item)
+		}
+
+		diff := target[n.axis] - n.p[n.axis]
+		if diff <= radius {
+			search(n.left)
+		}
+		if diff >= -radius {
+			search(n.right)
+		}
+	}
+	search(t.root)
+}
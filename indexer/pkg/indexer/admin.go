@@ -0,0 +1,341 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultResetTimeout is the time to wait until the consumer group session with new offsets starts.
+const DefaultResetTimeout = 45 * time.Second
+
+var (
+	// ErrInvalidRequest is returned when the admin request can't be executed with given arguments.
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrReplayRunning is returned when the replay is requested while the previous one is still running.
+	ErrReplayRunning = errors.New("replay is already running")
+
+	// ErrNotIndexing is returned when the replay is requested while the indexer doesn't index users.
+	ErrNotIndexing = errors.New("indexer is not indexing")
+)
+
+// pauser blocks consumption of the claims while consumption is paused.
+type pauser struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+}
+
+func newPauser() *pauser {
+	resumed := make(chan struct{})
+	close(resumed)
+
+	return &pauser{resumed: resumed}
+}
+
+// Pause stops consumption. Messages which were already received are indexed.
+func (p *pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.resumed:
+		p.resumed = make(chan struct{})
+	default:
+	}
+}
+
+// Resume starts consumption again.
+func (p *pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.resumed:
+	default:
+		close(p.resumed)
+	}
+}
+
+// Paused says if consumption is paused.
+func (p *pauser) Paused() bool {
+	select {
+	case <-p.Resumed():
+		return false
+	default:
+		return true
+	}
+}
+
+// Resumed returns the channel closed when consumption is not paused.
+func (p *pauser) Resumed() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.resumed
+}
+
+// offsetReset moves offsets of the partitions claimed by the next consumer group session.
+type offsetReset struct {
+	topic   string
+	offsets map[int32]int64
+	applied chan map[int32]int64
+}
+
+// apply sets offsets of the claimed partitions. Committed offset is moved backwards or forwards.
+func (r *offsetReset) apply(session sarama.ConsumerGroupSession) {
+	applied := make(map[int32]int64)
+	for _, partition := range session.Claims()[r.topic] {
+		offset, ok := r.offsets[partition]
+		if !ok {
+			continue
+		}
+
+		session.ResetOffset(r.topic, partition, offset, "")
+		session.MarkOffset(r.topic, partition, offset, "")
+		applied[partition] = offset
+	}
+
+	r.applied <- applied
+}
+
+// ReplayStatus describes the last replay started with the admin API.
+type ReplayStatus struct {
+	Running  bool       `json:"running"`
+	Topic    string     `json:"topic,omitempty"`
+	Index    string     `json:"index,omitempty"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Indexed  int        `json:"indexed"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Pause stops consumption of the topic until Resume is called.
+func (p *Indexer) Pause() {
+	p.consumer.pause.Pause()
+	log.Info("Consumption paused")
+}
+
+// Resume starts consumption stopped with Pause.
+func (p *Indexer) Resume() {
+	p.consumer.pause.Resume()
+	log.Info("Consumption resumed")
+}
+
+// Paused says if consumption is paused.
+func (p *Indexer) Paused() bool {
+	return p.consumer.pause.Paused()
+}
+
+// ResetOffsets moves the consumer group to given offsets of the partitions. Consumer group session is restarted
+// and offsets are applied to the partitions claimed by this instance - these are returned.
+func (p *Indexer) ResetOffsets(ctx context.Context, topic string, offsets map[int32]int64) (map[int32]int64, error) {
+	topic, err := p.requestTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("%w: no offsets given", ErrInvalidRequest)
+	}
+
+	for partition, offset := range offsets {
+		oldest, err := p.kafkaClient.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: can't get offsets of %s/%d: %v", ErrInvalidRequest, topic, partition, err)
+		}
+		newest, err := p.kafkaClient.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: can't get offsets of %s/%d: %v", ErrInvalidRequest, topic, partition, err)
+		}
+
+		if offset < oldest || offset > newest {
+			return nil, fmt.Errorf("%w: offset %d of %s/%d out of range [%d, %d]", ErrInvalidRequest, offset, topic, partition, oldest, newest)
+		}
+	}
+
+	return p.consumer.resetOffsets(ctx, &offsetReset{topic: topic, offsets: offsets, applied: make(chan map[int32]int64, 1)})
+}
+
+// requestTopic returns the topic of the admin request, the configured Topic when it's not given.
+// Topic must be given when the indexer consumes Topics or TopicPattern.
+func (p *Indexer) requestTopic(topic string) (string, error) {
+	if topic == "" {
+		topic = p.cfg.Topic
+	}
+	if topic == "" {
+		return "", fmt.Errorf("%w: topic is required", ErrInvalidRequest)
+	}
+
+	return topic, nil
+}
+
+// ResetOffsetsToTime moves the consumer group to the first messages produced at or after given time.
+// Partitions without such messages are moved to the end.
+func (p *Indexer) ResetOffsetsToTime(ctx context.Context, topic string, at time.Time) (map[int32]int64, error) {
+	topic, err := p.requestTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, err := offsetsForTime(p.kafkaClient, topic, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ResetOffsets(ctx, topic, offsets)
+}
+
+// Replay indexes messages of the topic produced in given time range into the index in the background.
+// Offsets of the consumer group are not changed. Progress is reported by ReplayStatus.
+// Replay is stopped when indexing stops and needs the elasticsearch sink.
+func (p *Indexer) Replay(ctx context.Context, topic, index string, from, to time.Time) error {
+	if !hasSink(p.sinks, sink.Elasticsearch) {
+		return fmt.Errorf("%w: replay needs the %s sink", ErrInvalidRequest, sink.Elasticsearch)
+	}
+	topic, err := p.requestTopic(topic)
+	if err != nil {
+		return err
+	}
+	if index == "" {
+		index = p.idx.write
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("%w: 'from' must be before 'to'", ErrInvalidRequest)
+	}
+
+	exists, err := p.esClient.IndexExists(index).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't check index %s: %w", index, err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: index %s doesn't exist", ErrInvalidRequest, index)
+	}
+
+	start, err := offsetsForTime(p.kafkaClient, topic, from)
+	if err != nil {
+		return err
+	}
+	end, err := offsetsForTime(p.kafkaClient, topic, to)
+	if err != nil {
+		return err
+	}
+
+	p.replayMu.Lock()
+	defer p.replayMu.Unlock()
+	if p.replay.Running {
+		return ErrReplayRunning
+	}
+	if p.replayCtx == nil {
+		return ErrNotIndexing
+	}
+	replayCtx := p.replayCtx
+	started := time.Now()
+	p.replay = ReplayStatus{Running: true, Topic: topic, Index: index, From: &from, To: &to, Started: &started}
+
+	p.replays.Add(1)
+	go func() {
+		defer p.replays.Done()
+
+		log.Infof("Replaying %s from %s to %s into %s", topic, from.Format(time.RFC3339), to.Format(time.RFC3339), index)
+		indexed, err := replayIntoIndex(replayCtx, p.kafkaClient, p.writer, p.esClient, p.consumer.decoder, topic, index, start, end)
+		if err != nil {
+			log.Errorf("Replay into %s failed. Indexed: %d. Err: %v", index, indexed, err)
+		} else {
+			log.Infof("Replay into %s finished. Indexed: %d", index, indexed)
+		}
+
+		p.replayMu.Lock()
+		defer p.replayMu.Unlock()
+		finished := time.Now()
+		p.replay.Running = false
+		p.replay.Finished = &finished
+		p.replay.Indexed = indexed
+		if err != nil {
+			p.replay.Error = err.Error()
+		}
+	}()
+
+	return nil
+}
+
+// ReplayStatus returns status of the last replay.
+func (p *Indexer) ReplayStatus() ReplayStatus {
+	p.replayMu.Lock()
+	defer p.replayMu.Unlock()
+
+	return p.replay
+}
+
+// resetOffsets restarts the consumer group session and waits until offsets are applied by Setup.
+func (consumer *Consumer) resetOffsets(ctx context.Context, reset *offsetReset) (map[int32]int64, error) {
+	consumer.mu.Lock()
+	consumer.reset = reset
+	restart := consumer.restart
+	consumer.mu.Unlock()
+
+	if restart == nil {
+		return nil, errors.New("consumer is not running")
+	}
+	log.Infof("Resetting offsets of %s to %v", reset.topic, reset.offsets)
+	restart()
+
+	timeout := time.NewTimer(DefaultResetTimeout)
+	defer timeout.Stop()
+
+	select {
+	case applied := <-reset.applied:
+		return applied, nil
+	case <-ctx.Done():
+		consumer.cancelReset(reset)
+		return nil, ctx.Err()
+	case <-timeout.C:
+		consumer.cancelReset(reset)
+		return nil, errors.New("timeout while waiting for the new consumer group session")
+	}
+}
+
+func (consumer *Consumer) cancelReset(reset *offsetReset) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	if consumer.reset == reset {
+		consumer.reset = nil
+	}
+}
+
+// offsetsForTime returns offsets of the first messages produced at or after given time.
+// Partitions without such messages get the newest offset.
+func offsetsForTime(client sarama.Client, topic string, at time.Time) (map[int32]int64, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: can't get partitions of %s: %v", ErrInvalidRequest, topic, err)
+	}
+
+	offsets := make(map[int32]int64)
+	for _, partition := range partitions {
+		offset, err := client.GetOffset(topic, partition, at.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return nil, fmt.Errorf("can't get offset of %s/%d at %s: %w", topic, partition, at.Format(time.RFC3339), err)
+		}
+
+		if offset == -1 {
+			if offset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return nil, fmt.Errorf("can't get newest offset of %s/%d: %w", topic, partition, err)
+			}
+		}
+		offsets[partition] = offset
+	}
+
+	return offsets, nil
+}
@@ -0,0 +1,389 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBulkMaxActions is used when BulkMaxActions is not set in config.
+	DefaultBulkMaxActions = 1000
+	// DefaultBulkMaxBytes is used when BulkMaxBytes is not set in config.
+	DefaultBulkMaxBytes = 5 << 20
+	// DefaultBulkFlushInterval is used when BulkFlushIntervalMs is not set in config.
+	DefaultBulkFlushInterval = time.Second
+	// DefaultBulkWorkers is used when BulkWorkers is not set in config.
+	DefaultBulkWorkers = 1
+	// DefaultBulkMaxRetries is used when BulkMaxRetries is not set in config.
+	DefaultBulkMaxRetries = 5
+
+	// retryBackoffMin and retryBackoffMax limit the wait before failed requests are sent again.
+	retryBackoffMin = 100 * time.Millisecond
+	retryBackoffMax = 30 * time.Second
+)
+
+// bulkWriter writes batches of documents to sinks. Failed calls are retried with the backoff and documents rejected
+// temporarily are retried up to max retries times. It's shared by the live indexing, replays, reindex and restore,
+// so all of them classify rejections of users the same way.
+type bulkWriter struct {
+	maxActions int
+	maxBytes   int64
+	maxRetries int
+
+	duration   *prometheus.HistogramVec
+	bulk       bulkMetrics
+	indexed    *prometheus.CounterVec
+	indexedErr *prometheus.CounterVec
+	conflicts  *prometheus.CounterVec
+}
+
+// newBulkWriter creates bulkWriter with limits from config. Metrics are registered or taken from the writer created before.
+func newBulkWriter(cfg *config.Config) *bulkWriter {
+	w := &bulkWriter{maxActions: cfg.BulkMaxActions, maxBytes: cfg.BulkMaxBytes, maxRetries: cfg.BulkMaxRetries}
+	if w.maxActions <= 0 {
+		w.maxActions = DefaultBulkMaxActions
+	}
+	if w.maxBytes <= 0 {
+		w.maxBytes = DefaultBulkMaxBytes
+	}
+	if w.maxRetries <= 0 {
+		w.maxRetries = DefaultBulkMaxRetries
+	}
+
+	w.indexed = registerCounterVec(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "indexed_total",
+			Help:      "The total number of indexed users.",
+		},
+		[]string{"index"},
+	))
+
+	w.indexedErr = registerCounterVec(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "indexed_total_err",
+			Help:      "The total number of errors during indexing users.",
+		},
+		[]string{"index"},
+	))
+
+	w.conflicts = registerCounterVec(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "version_conflicts_total",
+			Help:      "The total number of users skipped because newer version was already indexed.",
+		},
+		[]string{"index"},
+	))
+
+	w.duration = registerHistogramVec(prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "sink_write_duration_seconds",
+			Help:      "Seconds spent writing batches of users to the sink.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"sink"},
+	))
+
+	w.bulk = newBulkMetrics()
+
+	return w
+}
+
+// writeTo applies documents to the sink and flushes it. Returned reasons of rejections correspond to documents -
+// empty when the document is applied. Runs of deletes and writes are sent in separate calls, so operations keep
+// their order. Users rejected temporarily are retried BulkMaxRetries times, other rejections are permanent.
+// Once the operation on the user is rejected temporarily, later operations on the same user in the batch are held
+// back and retried together with it in their original order, so the older operation never overwrites the newer one.
+// Retries stop once the context is cancelled and the error is returned, as the batch isn't fully written.
+func (w *bulkWriter) writeTo(ctx context.Context, s sink.Sink, docs []*document) ([]string, error) {
+	label := sinkLabel(s)
+	started := time.Now()
+	reasons := make([]string, len(docs))
+	attempts := make([]int, len(docs))
+
+	pending := make([]int, len(docs))
+	for i := range docs {
+		pending[i] = i
+	}
+
+	var indexed, conflicts, failed int
+	for round := 1; len(pending) > 0; round++ {
+		if round > 1 && !sleepBackoff(ctx, round-1) {
+			return nil, ctx.Err()
+		}
+
+		// users with the operation rejected temporarily in this round
+		held := make(map[string]bool)
+
+		var retry []int
+		for first := 0; first < len(pending); {
+			deletes := docs[pending[first]].ev.op == OpDelete
+			last := first + 1
+			for last < len(pending) && (docs[pending[last]].ev.op == OpDelete) == deletes {
+				last++
+			}
+			run := pending[first:last]
+			first = last
+
+			var (
+				sent  []int
+				ops   []sink.Operation
+				bytes int64
+			)
+			for _, i := range run {
+				if !held[docs[i].ev.id] {
+					sent = append(sent, i)
+					ops = append(ops, docs[i].ev.operation())
+					bytes += int64(len(docs[i].msg.Value))
+				}
+			}
+
+			results := make(map[int]error, len(sent))
+			if len(ops) > 0 {
+				errs, err := w.apply(ctx, s, ops, deletes, bytes)
+				if err != nil {
+					return nil, err
+				}
+				for k, err := range errs {
+					results[sent[k]] = err
+				}
+			}
+
+			for _, i := range run {
+				id := docs[i].ev.id
+				if held[id] {
+					// sent again after the rejected operation, even when it was already applied
+					retry = append(retry, i)
+					continue
+				}
+
+				switch err := results[i]; {
+				case err == nil:
+					indexed++
+				case err == sink.ErrConflict:
+					conflicts++
+				case sink.IsTemporary(err) && attempts[i] < w.maxRetries:
+					attempts[i]++
+					held[id] = true
+					retry = append(retry, i)
+				default:
+					failed++
+					reasons[i] = fmt.Sprintf("%s: %v", s.Name(), err)
+					log.Errorf("can't %s user %s in %s. Reason: %v", docs[i].ev.op, id, s.Name(), err)
+				}
+			}
+		}
+		pending = retry
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.Flush(context.Background())
+		if err == nil {
+			break
+		}
+		log.Errorf("can't flush %s, retrying. Err: %v", s.Name(), err)
+		if !sleepBackoff(ctx, attempt) {
+			return nil, ctx.Err()
+		}
+	}
+
+	w.duration.WithLabelValues(s.Name()).Observe(time.Since(started).Seconds())
+	w.indexed.WithLabelValues(label).Add(float64(indexed))
+	w.conflicts.WithLabelValues(label).Add(float64(conflicts))
+	w.indexedErr.WithLabelValues(label).Add(float64(failed))
+	log.Infof("Batch with %v users written to %s! Skipped as outdated: %v, failed: %v", indexed, s.Name(), conflicts, failed)
+
+	return reasons, nil
+}
+
+// apply sends operations to the sink until the call succeeds and returns the outcome of each operation.
+// Each call is observed by bulk metrics with the size of messages of the users as its size. Operations are sent
+// at least once, so users drained on shutdown are written, but failed calls aren't retried once the context is cancelled.
+func (w *bulkWriter) apply(ctx context.Context, s sink.Sink, ops []sink.Operation, deletes bool, bytes int64) ([]error, error) {
+	for attempt := 1; ; attempt++ {
+		var (
+			errs []error
+			err  error
+		)
+		start := time.Now()
+		if deletes {
+			errs, err = s.Delete(context.Background(), ops)
+		} else {
+			errs, err = s.Write(context.Background(), ops)
+		}
+		w.bulk.duration.WithLabelValues(s.Name()).Observe(time.Since(start).Seconds())
+		w.bulk.actions.WithLabelValues(s.Name()).Observe(float64(len(ops)))
+		w.bulk.size.WithLabelValues(s.Name()).Observe(float64(bytes))
+		if err == nil && len(errs) != len(ops) {
+			err = fmt.Errorf("got %d results for %d operations", len(errs), len(ops))
+		}
+		if err == nil {
+			return errs, nil
+		}
+
+		log.Errorf("can't write to %s, retrying. Err: %v", s.Name(), err)
+		if !sleepBackoff(ctx, attempt) {
+			return nil, fmt.Errorf("can't write to %s: %w", s.Name(), err)
+		}
+	}
+}
+
+// loader writes documents to the single sink in batches of max actions or max bytes. It's used by replays,
+// reindex and restore, which don't track offsets of the documents.
+type loader struct {
+	w     *bulkWriter
+	s     sink.Sink
+	batch []*document
+	size  int64
+
+	// written counts documents applied or skipped as outdated, rejected the ones rejected by the sink.
+	written  int
+	rejected int
+}
+
+// Add adds the document to the batch and writes the batch once it's full.
+func (l *loader) Add(ctx context.Context, doc *document) error {
+	l.batch = append(l.batch, doc)
+	l.size += int64(len(doc.msg.Value))
+	if len(l.batch) >= l.w.maxActions || l.size >= l.w.maxBytes {
+		return l.Flush(ctx)
+	}
+
+	return nil
+}
+
+// Flush writes the current batch. Error means that the context was cancelled before the batch was written.
+func (l *loader) Flush(ctx context.Context) error {
+	if len(l.batch) == 0 {
+		return nil
+	}
+
+	reasons, err := l.w.writeTo(ctx, l.s, l.batch)
+	if err != nil {
+		return err
+	}
+	for _, reason := range reasons {
+		if reason != "" {
+			l.rejected++
+		} else {
+			l.written++
+		}
+	}
+	l.batch, l.size = nil, 0
+
+	return nil
+}
+
+// bulkMetrics describe requests writing users to sinks.
+type bulkMetrics struct {
+	duration *prometheus.HistogramVec
+	actions  *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+}
+
+// newBulkMetrics registers bulk metrics or returns the ones registered before.
+func newBulkMetrics() bulkMetrics {
+	duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_duration_seconds",
+			Help:      "Seconds spent executing bulk requests.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"sink"},
+	)
+
+	actions := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_size_actions",
+			Help:      "The number of actions in executed bulk requests.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		},
+		[]string{"sink"},
+	)
+
+	size := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_size_bytes",
+			Help:      "The estimated size of executed bulk requests.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+		},
+		[]string{"sink"},
+	)
+
+	return bulkMetrics{
+		duration: registerHistogramVec(duration),
+		actions:  registerHistogramVec(actions),
+		size:     registerHistogramVec(size),
+	}
+}
+
+// registerCounterVec registers the counter or returns the one registered before with the same name.
+func registerCounterVec(c *prometheus.CounterVec) *prometheus.CounterVec {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing
+			}
+		}
+	}
+
+	return c
+}
+
+// registerHistogramVec registers the histogram or returns the one registered before with the same name.
+func registerHistogramVec(h *prometheus.HistogramVec) *prometheus.HistogramVec {
+	if err := prometheus.Register(h); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.HistogramVec); ok {
+				return existing
+			}
+		}
+	}
+
+	return h
+}
+
+// sleepBackoff waits before the next attempt. Returns false when the context is cancelled first.
+func sleepBackoff(ctx context.Context, attempt int) bool {
+	select {
+	case <-time.After(backoff(attempt)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns exponentially growing wait time for given attempt.
+// Half of the wait is random, so workers retrying at the same time don't hit the cluster together.
+func backoff(attempt int) time.Duration {
+	d := retryBackoffMin
+	for i := 1; i < attempt && d < retryBackoffMax; i++ {
+		d *= 2
+	}
+
+	if d > retryBackoffMax {
+		d = retryBackoffMax
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultDLQTopic is used when DLQTopic is not set in config.
+const DefaultDLQTopic = "users.dlq"
+
+// Headers of the dead-letter messages.
+const (
+	HeaderError     = "error"
+	HeaderTopic     = "topic"
+	HeaderPartition = "partition"
+	HeaderOffset    = "offset"
+	HeaderTimestamp = "timestamp"
+	HeaderFailedAt  = "failed_at"
+
+	// HeaderOriginalPrefix prefixes headers of the original message, so they don't clash with the headers above.
+	HeaderOriginalPrefix = "original."
+)
+
+// deadLetters publishes messages which can't be indexed to the dead-letter topic.
+type deadLetters struct {
+	producer  sarama.SyncProducer
+	topic     string
+	published *prometheus.CounterVec
+}
+
+func newDeadLetters(client sarama.Client, topic string) (*deadLetters, error) {
+	if topic == "" {
+		topic = DefaultDLQTopic
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("can't create dead-letter producer: %w", err)
+	}
+
+	published := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "dead_lettered_total",
+			Help:      "The total number of messages published to the dead-letter topic.",
+		},
+		[]string{"topic"},
+	)
+
+	prometheus.Register(published)
+
+	return &deadLetters{
+		producer:  producer,
+		topic:     topic,
+		published: published,
+	}, nil
+}
+
+// Publish sends the original message to the dead-letter topic.
+// Headers carry the reason of the failure, the position of the message in the source topic and its own headers.
+func (d *deadLetters) Publish(msg *sarama.ConsumerMessage, reason string) error {
+	_, _, err := d.producer.SendMessage(deadLetter(d.topic, msg, reason))
+	if err != nil {
+		return fmt.Errorf("can't publish message to dead-letter topic: %w", err)
+	}
+
+	d.published.WithLabelValues(msg.Topic).Inc()
+
+	return nil
+}
+
+// deadLetter creates the message of the dead-letter topic from the original message.
+func deadLetter(topic string, msg *sarama.ConsumerMessage, reason string) *sarama.ProducerMessage {
+	dead := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: value(msg),
+		Headers: []sarama.RecordHeader{
+			header(HeaderError, reason),
+			header(HeaderTopic, msg.Topic),
+			header(HeaderPartition, strconv.Itoa(int(msg.Partition))),
+			header(HeaderOffset, strconv.FormatInt(msg.Offset, 10)),
+			header(HeaderTimestamp, msg.Timestamp.Format(time.RFC3339Nano)),
+			header(HeaderFailedAt, time.Now().Format(time.RFC3339Nano)),
+		},
+	}
+	for _, h := range msg.Headers {
+		dead.Headers = append(dead.Headers, sarama.RecordHeader{Key: []byte(HeaderOriginalPrefix + string(h.Key)), Value: h.Value})
+	}
+
+	return dead
+}
+
+// Close closes the producer.
+func (d *deadLetters) Close() error {
+	return d.producer.Close()
+}
+
+func header(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
+
+// value returns the value of the message keeping tombstones as null values.
+func value(msg *sarama.ConsumerMessage) sarama.Encoder {
+	if msg.Value == nil {
+		return nil
+	}
+
+	return sarama.ByteEncoder(msg.Value)
+}
@@ -0,0 +1,170 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/enrich"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations on the user which can be requested by Kafka message.
+const (
+	OpIndex  = sink.OpIndex
+	OpUpdate = sink.OpUpdate
+	OpDelete = sink.OpDelete
+)
+
+// HeaderOp is the header of Kafka message with the operation on the user.
+// When the header is missing, the operation is taken from the "op" field of the message.
+// Message without value (tombstone) deletes the user with id taken from the key.
+const HeaderOp = "op"
+
+// event is the operation on the user read from Kafka message.
+type event struct {
+	op      string
+	id      string
+	index   string
+	user    models.User
+	partial map[string]interface{}
+	version int64
+}
+
+// decoder reads events from Kafka messages and enriches indexed users.
+type decoder struct {
+	versions versioning
+	enrich   enrich.Chain
+	routing  routing
+}
+
+func newDecoder(cfg *config.Config) (decoder, error) {
+	versions, err := newVersioning(cfg)
+	if err != nil {
+		return decoder{}, err
+	}
+
+	routing, err := newRouting(cfg)
+	if err != nil {
+		return decoder{}, err
+	}
+
+	opts := enrich.Options{
+		GeohashPrecision:     cfg.GeohashPrecision,
+		GeocodeMaxDistanceKm: cfg.GeocodeMaxDistanceKm,
+	}
+	if cfg.GazetteerFile != "" {
+		if opts.Gazetteer, err = enrich.LoadGazetteer(cfg.GazetteerFile, cfg.GazetteerAdmin1File); err != nil {
+			return decoder{}, fmt.Errorf("can't load gazetteer: %w", err)
+		}
+		log.Infof("Gazetteer loaded from %s: %d places", cfg.GazetteerFile, opts.Gazetteer.Len())
+	}
+
+	chain, err := enrich.New(cfg.Enrichers, opts)
+	if err != nil {
+		return decoder{}, fmt.Errorf("can't create enrichment chain: %w", err)
+	}
+
+	return decoder{versions: versions, enrich: chain, routing: routing}, nil
+}
+
+// Decode reads the event from Kafka message and picks its index. Fields of the user which can't be derived are skipped.
+func (d decoder) Decode(msg *sarama.ConsumerMessage) (event, error) {
+	ev, err := decodeEvent(msg, d.versions)
+	if err != nil {
+		return ev, err
+	}
+
+	if ev.op == OpIndex {
+		for _, err := range d.enrich.Enrich(&ev.user) {
+			log.Warnf("can't enrich user %s. Err: %v", ev.id, err)
+		}
+	}
+	ev.index = d.routing.Index(msg, ev)
+
+	return ev, nil
+}
+
+// decodeEvent reads the operation, the user and its version from Kafka message.
+func decodeEvent(msg *sarama.ConsumerMessage, v versioning) (event, error) {
+	ev := event{op: headerValue(msg, HeaderOp), id: string(msg.Key)}
+
+	if msg.Value == nil {
+		if ev.op != "" && ev.op != OpDelete {
+			return ev, fmt.Errorf("tombstone with %s operation", ev.op)
+		}
+		if ev.id == "" {
+			return ev, errors.New("tombstone without key")
+		}
+		ev.op = OpDelete
+
+		var err error
+		ev.version, err = v.version(msg, nil)
+		return ev, err
+	}
+
+	fields := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(msg.Value))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return ev, err
+	}
+
+	if op, ok := fields["op"].(string); ok && ev.op == "" {
+		ev.op = op
+	}
+	delete(fields, "op")
+
+	if id, ok := fields["id"]; ok && id != nil {
+		ev.id = fmt.Sprint(id)
+	}
+
+	if ev.op == "" {
+		ev.op = OpIndex
+	}
+
+	switch ev.op {
+	case OpIndex:
+		user, err := decodeUser(msg)
+		if err != nil {
+			return ev, err
+		}
+		ev.user = user
+	case OpUpdate:
+		if dob, ok := fields["dob"].(string); ok && dob == "0000-00-00" {
+			fields["dob"] = nil
+		}
+		ev.partial = fields
+	case OpDelete:
+	default:
+		return ev, fmt.Errorf("unknown operation %q", ev.op)
+	}
+
+	if ev.id == "" {
+		return ev, fmt.Errorf("%s operation without user id", ev.op)
+	}
+
+	version, err := v.version(msg, fields)
+	if err != nil {
+		return ev, err
+	}
+	ev.version = version
+
+	return ev, nil
+}
+
+// operation returns the operation written to sinks.
+func (ev event) operation() sink.Operation {
+	op := sink.Operation{Op: ev.op, ID: ev.id, Index: ev.index, Partial: ev.partial, Version: ev.version}
+	if ev.op == OpIndex {
+		user := ev.user
+		op.User = &user
+	}
+
+	return op
+}
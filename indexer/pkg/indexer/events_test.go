@@ -0,0 +1,146 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		op      string
+		value   []byte
+		wantOp  string
+		wantID  string
+		partial int
+		wantErr bool
+	}{
+		{
+			name:   "index by default",
+			key:    "1",
+			value:  []byte(`{"id": 1, "nickname": "john"}`),
+			wantOp: OpIndex,
+			wantID: "1",
+		},
+		{
+			name:   "id from the value",
+			key:    "other",
+			value:  []byte(`{"id": 7}`),
+			wantOp: OpIndex,
+			wantID: "7",
+		},
+		{
+			name:    "update from the field",
+			value:   []byte(`{"op": "update", "id": 1, "nickname": "john"}`),
+			wantOp:  OpUpdate,
+			wantID:  "1",
+			partial: 2,
+		},
+		{
+			name:    "header wins over the field",
+			op:      OpUpdate,
+			value:   []byte(`{"op": "index", "id": 1, "city": "Cracow"}`),
+			wantOp:  OpUpdate,
+			wantID:  "1",
+			partial: 2,
+		},
+		{
+			name:   "delete from the header",
+			op:     OpDelete,
+			key:    "3",
+			value:  []byte(`{}`),
+			wantOp: OpDelete,
+			wantID: "3",
+		},
+		{
+			name:   "tombstone",
+			key:    "4",
+			wantOp: OpDelete,
+			wantID: "4",
+		},
+		{
+			name:   "tombstone with delete header",
+			key:    "4",
+			op:     OpDelete,
+			wantOp: OpDelete,
+			wantID: "4",
+		},
+		{
+			name:    "tombstone without key",
+			wantErr: true,
+		},
+		{
+			name:    "tombstone with update header",
+			key:     "4",
+			op:      OpUpdate,
+			wantErr: true,
+		},
+		{
+			name:    "unknown operation",
+			value:   []byte(`{"op": "upsert", "id": 1}`),
+			wantErr: true,
+		},
+		{
+			name:    "update without id",
+			value:   []byte(`{"op": "update", "nickname": "john"}`),
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			key:     "1",
+			value:   []byte(`{"id": `),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Key: []byte(tt.key), Value: tt.value}
+			if tt.key == "" {
+				msg.Key = nil
+			}
+			if tt.op != "" {
+				msg.Headers = []*sarama.RecordHeader{{Key: []byte(HeaderOp), Value: []byte(tt.op)}}
+			}
+
+			ev, err := decodeEvent(msg, versioning{source: VersionNone})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ev.op != tt.wantOp || ev.id != tt.wantID {
+				t.Errorf("got %s of %q, want %s of %q", ev.op, ev.id, tt.wantOp, tt.wantID)
+			}
+			if len(ev.partial) != tt.partial {
+				t.Errorf("partial fields: got %v, want %d", ev.partial, tt.partial)
+			}
+			if _, ok := ev.partial["op"]; ok {
+				t.Errorf("op field is written to the partial document")
+			}
+		})
+	}
+}
+
+func TestDecodeEventDob(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Value: []byte(`{"id": 1, "dob": "0000-00-00"}`)}
+	ev, err := decodeEvent(msg, versioning{source: VersionNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.user.Dob != nil {
+		t.Errorf("empty dob is indexed: %s", *ev.user.Dob)
+	}
+
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte(HeaderOp), Value: []byte(OpUpdate)}}
+	if ev, err = decodeEvent(msg, versioning{source: VersionNone}); err != nil {
+		t.Fatal(err)
+	}
+	if dob, ok := ev.partial["dob"]; !ok || dob != nil {
+		t.Errorf("empty dob is not cleared in the update: %v", dob)
+	}
+}
@@ -2,70 +2,111 @@ package indexer
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"os"
-	"os/signal"
+	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
 	"github.com/mateuszdyminski/am-pipeline/models"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
 	elastic "github.com/olivere/elastic/v7"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultConsumerGroup is used when ConsumerGroup is not set in config.
+const DefaultConsumerGroup = "consumer-group"
+
+// Rebalance strategies of the consumer group.
+const (
+	RebalanceRoundRobin = "roundrobin"
+	RebalanceRange      = "range"
+)
+
+// Isolation levels of the consumer.
+const (
+	IsolationReadUncommitted = "read_uncommitted"
+	IsolationReadCommitted   = "read_committed"
+)
+
 // Indexer allows to Index data taken from Kafka in ElasticSearch
 type Indexer struct {
 	cfg           *config.Config
+	kafkaClient   sarama.Client
 	kafkaConsumer sarama.ConsumerGroup
+	consumer      *Consumer
 	esClient      *elastic.Client
-	indexed       *prometheus.CounterVec
-	indexedErr    *prometheus.CounterVec
+	idx           indices
+	dlq           *deadLetters
+	sinks         []sink.Sink
+	writer        *bulkWriter
 	received      *prometheus.CounterVec
 	receivedErr   *prometheus.CounterVec
+	stats         *stats
+	subscription  *subscription
+	kafkaCheck    checks.Probe
+
+	replayMu  sync.Mutex
+	replay    ReplayStatus
+	replayCtx context.Context // context of Index, replays are started only while indexing
+	replays   sync.WaitGroup
 }
 
 // NewIndexer creates new Indexer.
 func NewIndexer(cfg *config.Config) (*Indexer, error) {
-	// kafka consumer group initialization
-	config := sarama.NewConfig()
-	config.Version = sarama.V2_3_0_0
-	config.Consumer.Return.Errors = true
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	if cfg.ReadFromOldest {
-		config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	}
-
 	// init consumer
 	brokers := cfg.Brokers
-	group := "consumer-group"
+	group := cfg.ConsumerGroup
+	if group == "" {
+		group = DefaultConsumerGroup
+	}
 
-	kafkaConsumer, err := sarama.NewConsumerGroup(brokers, group, config)
+	subscription, err := newSubscription(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaCfg, err := kafkaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	kafkaClient, err := sarama.NewClient(brokers, kafkaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error while init kafka client. err: %s", err)
+	}
+
+	kafkaConsumer, err := sarama.NewConsumerGroupFromClient(group, kafkaClient)
 	if err != nil {
 		return nil, fmt.Errorf("error while init consumer group. err: %s", err)
 	}
 
-	// elasticsearch client initialization
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	dlq, err := newDeadLetters(kafkaClient, cfg.DLQTopic)
+	if err != nil {
+		return nil, err
 	}
-	httpClient := &http.Client{Transport: tr}
 
-	// connect to the cluster
-	client, err := elastic.NewClient(
-		elastic.SetURL(cfg.Elastics...),
-		elastic.SetBasicAuth(cfg.ElasticUser, cfg.ElasticPassword),
-		elastic.SetHttpClient(httpClient),
-		elastic.SetSniff(false),
-		elastic.SetScheme("https"),
-	)
+	decoder, err := newDecoder(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("can't create elastic client. err: %v", err)
+		return nil, err
+	}
+
+	client, err := esclient.New(cfg.Elastic)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := newIndices(cfg)
+	sinks, err := newSinks(cfg, client, idx)
+	if err != nil {
+		return nil, err
 	}
 
 	received := prometheus.NewCounterVec(
@@ -88,208 +129,591 @@ func NewIndexer(cfg *config.Config) (*Indexer, error) {
 		[]string{"topic"},
 	)
 
-	indexed := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "am",
-			Subsystem: "indexer",
-			Name:      "indexed_total",
-			Help:      "The total number of indexed users.",
-		},
-		[]string{"index"},
-	)
-
-	indexedErr := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "am",
-			Subsystem: "indexer",
-			Name:      "indexed_total_err",
-			Help:      "The total number of errors during indexing users.",
-		},
-		[]string{"index"},
-	)
-
 	prometheus.Register(received)
 	prometheus.Register(receivedErr)
-	prometheus.Register(indexed)
-	prometheus.Register(indexedErr)
+
+	stats := newStats(group)
+
+	router, err := newRouter(cfg.BulkWorkers, cfg.WorkerQueueSize, cfg.WorkerRouting)
+	if err != nil {
+		return nil, err
+	}
+
+	/**
+	 * Setup a new Sarama consumer group
+	 */
+	consumer := &Consumer{
+		router:      router,
+		dlq:         dlq,
+		decoder:     decoder,
+		pause:       newPauser(),
+		stopped:     make(chan struct{}),
+		stats:       stats,
+		received:    received,
+		receivedErr: receivedErr,
+	}
 
 	indexer := &Indexer{
 		cfg:           cfg,
+		consumer:      consumer,
+		kafkaClient:   kafkaClient,
 		kafkaConsumer: kafkaConsumer,
 		esClient:      client,
-		indexed:       indexed,
-		indexedErr:    indexedErr,
+		idx:           idx,
+		dlq:           dlq,
+		sinks:         sinks,
+		writer:        newBulkWriter(cfg),
 		received:      received,
 		receivedErr:   receivedErr,
+		stats:         stats,
+		subscription:  subscription,
 	}
+	indexer.kafkaCheck = checks.Blocking(indexer.checkKafka)
 
 	return indexer, nil
 }
 
-// Index starts reading data from Kafka and indexing it in ELastic.
-func (p *Indexer) Index() error {
-	p.indexUsers(p.streamUsers())
+// kafkaConfig returns config of the Kafka client used by the consumer group and dead-letter producer.
+// Settings missing in config keep Sarama defaults.
+func kafkaConfig(cfg *config.Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_3_0_0
+	config.Consumer.Return.Errors = true
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	if cfg.ReadFromOldest {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	switch cfg.RebalanceStrategy {
+	case "", RebalanceRoundRobin:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case RebalanceRange:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	default:
+		return nil, fmt.Errorf("unknown rebalance strategy %q", cfg.RebalanceStrategy)
+	}
+
+	switch cfg.IsolationLevel {
+	case "", IsolationReadUncommitted:
+		config.Consumer.IsolationLevel = sarama.ReadUncommitted
+	case IsolationReadCommitted:
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	default:
+		return nil, fmt.Errorf("unknown isolation level %q", cfg.IsolationLevel)
+	}
+
+	if cfg.SessionTimeoutMs > 0 {
+		config.Consumer.Group.Session.Timeout = time.Duration(cfg.SessionTimeoutMs) * time.Millisecond
+	}
+	if cfg.HeartbeatIntervalMs > 0 {
+		config.Consumer.Group.Heartbeat.Interval = time.Duration(cfg.HeartbeatIntervalMs) * time.Millisecond
+	}
+	if cfg.FetchMinBytes > 0 {
+		config.Consumer.Fetch.Min = cfg.FetchMinBytes
+	}
+	if cfg.FetchDefaultBytes > 0 {
+		config.Consumer.Fetch.Default = cfg.FetchDefaultBytes
+	}
+	if cfg.FetchMaxBytes > 0 {
+		config.Consumer.Fetch.Max = cfg.FetchMaxBytes
+	}
+	if cfg.FetchMaxWaitMs > 0 {
+		config.Consumer.MaxWaitTime = time.Duration(cfg.FetchMaxWaitMs) * time.Millisecond
+	}
+
+	if err := cfg.Kafka.Apply(config); err != nil {
+		return nil, fmt.Errorf("can't set up kafka security: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid kafka config: %w", err)
+	}
+
+	return config, nil
+}
+
+// Index starts reading data from Kafka and writing it to sinks until the context is cancelled.
+// Then consumption stops, received users are written, their offsets are committed and clients are closed.
+func (p *Indexer) Index(ctx context.Context) error {
+	defer p.close()
+
+	if hasSink(p.sinks, sink.Elasticsearch) {
+		err := ensureIndex(ctx, p.esClient, p.idx)
+		if err == ErrIncompatibleMapping && p.cfg.ReindexOnDrift {
+			err = p.reindexOnDrift()
+		}
+		if err == nil && len(p.consumer.decoder.routing.routes) > 0 {
+			err = ensureRoutedIndices(ctx, p.esClient, p.idx, p.consumer.decoder.routing)
+		}
+		if err != nil {
+			return fmt.Errorf("can't prepare index: %w", err)
+		}
+	}
+
+	// replays run with the context of Index and are waited for before clients are closed
+	p.replayMu.Lock()
+	p.replayCtx = ctx
+	p.replayMu.Unlock()
+	defer func() {
+		p.replayMu.Lock()
+		p.replayCtx = nil
+		p.replayMu.Unlock()
+		p.replays.Wait()
+	}()
+
+	// consumer group session is kept until users are flushed, so their offsets can be committed
+	consuming, stopConsuming := context.WithCancel(context.Background())
+	wait := p.streamUsers(consuming)
+	p.indexUsers(ctx, p.consumer.router.queues)
+
+	log.Info("Committing offsets and leaving the consumer group")
+	stopConsuming()
+	wait()
 
 	return nil
 }
 
-// BulkSize size of the bulk.
-const BulkSize = 1
+// close releases Kafka clients and sinks.
+func (p *Indexer) close() {
+	if err := p.kafkaConsumer.Close(); err != nil {
+		log.Errorf("Error closing consumer: %v", err)
+	}
+	if err := p.dlq.Close(); err != nil {
+		log.Errorf("Error closing dead-letter producer: %v", err)
+	}
+	if err := p.kafkaClient.Close(); err != nil {
+		log.Errorf("Error closing client: %v", err)
+	}
+	closeSinks(p.sinks)
+	p.esClient.Stop()
+}
 
-func (p *Indexer) indexUsers(users chan models.User) {
-	exists, err := p.esClient.IndexExists("users").Do(context.Background())
-	if err != nil {
-		log.Fatalf("Can't check if index exists. Err: %v", err)
+// Sinks returns sinks which users are written to.
+func (p *Indexer) Sinks() []sink.Sink {
+	return p.sinks
+}
+
+// Status returns the summary of the consumer group membership, lag of the claimed partitions and the last replay.
+func (p *Indexer) Status() Status {
+	status := p.stats.Status()
+	status.Paused = p.Paused()
+	status.Replay = p.ReplayStatus()
+
+	return status
+}
+
+// CheckKafka fetches metadata of the consumed topics to verify that Kafka brokers are reachable.
+// Kafka client doesn't accept the context, so the check returns once the context is done
+// and later checks wait for the pending fetch.
+func (p *Indexer) CheckKafka(ctx context.Context) error {
+	return p.kafkaCheck(ctx)
+}
+
+func (p *Indexer) checkKafka() error {
+	topics := p.subscription.Current()
+	if len(topics) == 0 {
+		return errors.New("no topics subscribed")
+	}
+
+	if err := p.kafkaClient.RefreshMetadata(topics...); err != nil {
+		return fmt.Errorf("can't fetch metadata: %w", err)
 	}
 
-	if !exists {
-		log.Info("Creating index 'users'")
-		// Create an index if not exists
-		_, err = p.esClient.
-			CreateIndex("users").
-			BodyString(models.ElasticMappingString).
-			Do(context.Background())
+	for _, topic := range topics {
+		partitions, err := p.kafkaClient.Partitions(topic)
 		if err != nil {
-			log.Fatalf("Can't create index. Err: %v", err)
+			return fmt.Errorf("can't get partitions of %s: %w", topic, err)
+		}
+
+		if len(partitions) == 0 {
+			return fmt.Errorf("no partitions of %s found", topic)
 		}
 	}
 
-	var enqued int
-	bulkRequest := p.esClient.Bulk()
-	for user := range users {
-		if enqued > 0 && enqued%BulkSize == 0 {
-			if _, err := bulkRequest.Do(context.Background()); err != nil {
-				p.indexedErr.WithLabelValues("users").Inc()
-				log.Error("can't execute bulk. Err: %v", err)
-				continue
-			}
+	return nil
+}
+
+// CheckConsumerGroup verifies that indexer is an active member of the consumer group.
+func (p *Indexer) CheckConsumerGroup(ctx context.Context) error {
+	if atomic.LoadInt32(&p.consumer.member) == 0 {
+		return errors.New("consumer is not a member of the group")
+	}
+
+	return nil
+}
+
+// indexUsers runs the worker of each queue until the context is cancelled. Then consumption is stopped,
+// users which are already received are written and the last batches are flushed.
+func (p *Indexer) indexUsers(ctx context.Context, queues []chan *document) {
+	stopping := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		wg.Add(1)
+		go func(queue chan *document) {
+			defer wg.Done()
+			p.work(ctx, queue, stopping)
+		}(queue)
+	}
+
+	<-ctx.Done()
+	log.Info("Stopping consumption and flushing received users")
+	p.consumer.stop()
+	close(stopping)
+	wg.Wait()
+}
+
+// work writes documents from the queue in order. Documents are grouped into batches of max actions or max bytes,
+// or sent when flush interval passes. The next batch is written only when the previous one is written to all sinks.
+func (p *Indexer) work(ctx context.Context, queue chan *document, stopping chan struct{}) {
+	flushInterval := time.Duration(p.cfg.BulkFlushIntervalMs) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = DefaultBulkFlushInterval
+	}
 
-			p.indexed.WithLabelValues("users").Add(BulkSize)
-			log.Infof("Bulk with %v users indexed! Total indexed users: %v", BulkSize, enqued)
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
 
-			bulkRequest = p.esClient.Bulk()
+	var (
+		batch []*document
+		size  int64
+	)
+	add := func(doc *document) {
+		batch = append(batch, doc)
+		size += int64(len(doc.msg.Value))
+		if len(batch) >= p.writer.maxActions || size >= p.writer.maxBytes {
+			p.write(ctx, batch)
+			batch, size = nil, 0
 		}
+	}
 
-		bulkRequest.Add(
-			elastic.NewBulkIndexRequest().
-				Index("users").
-				Type("_doc").
-				Id(fmt.Sprintf("%d", user.Pnum)).
-				Doc(user))
+	for {
+		select {
+		case doc := <-queue:
+			add(doc)
+		case <-flush.C:
+			p.write(ctx, batch)
+			batch, size = nil, 0
+		case <-stopping:
+			for {
+				select {
+				case doc := <-queue:
+					add(doc)
+				default:
+					p.write(ctx, batch)
+					return
+				}
+			}
+		}
+	}
+}
 
-		enqued++
+// reindexOnDrift rebuilds the index with the desired mapping in the next version of the physical index.
+func (p *Indexer) reindexOnDrift() error {
+	targets, _, err := resolveAlias(context.Background(), p.esClient, p.idx.write)
+	if err != nil {
+		return err
 	}
 
-	if bulkRequest.NumberOfActions() > 0 {
-		if _, err := bulkRequest.Do(context.Background()); err != nil {
-			log.Fatalf("Can't execute bulk. Err: %v", err)
+	version := p.idx.version
+	for _, target := range targets {
+		if v := indexVersion(p.idx, target); v >= version {
+			version = v + 1
 		}
 	}
+
+	log.Warnf("Mapping is incompatible, reindexing into '%s'", p.idx.Physical(version))
+	if err := Reindex(p.cfg, ReindexFromIndex, version); err != nil {
+		return err
+	}
+
+	return ensureIndex(context.Background(), p.esClient, p.idx)
 }
 
-func (p *Indexer) streamUsers() chan models.User {
-	out := make(chan models.User, 1024)
-	topics := []string{p.cfg.Topic}
-	ctx, cancel := context.WithCancel(context.Background())
+// write applies the batch to all sinks in parallel and marks offsets of documents once every sink applied them.
+// Documents rejected by any sink are published to the dead-letter topic. When the context is cancelled before
+// the batch is written, offsets of its documents stay open, so they are consumed again after the restart.
+func (p *Indexer) write(ctx context.Context, docs []*document) {
+	if len(docs) == 0 {
+		return
+	}
 
-	/**
-	 * Setup a new Sarama consumer group
-	 */
-	consumer := Consumer{
-		out:         out,
-		ready:       make(chan bool),
-		received:    p.received,
-		receivedErr: p.receivedErr,
+	rejections := make([][]string, len(p.sinks))
+	errs := make([]error, len(p.sinks))
+	var wg sync.WaitGroup
+	for i, s := range p.sinks {
+		wg.Add(1)
+		go func(i int, s sink.Sink) {
+			defer wg.Done()
+			rejections[i], errs[i] = p.writer.writeTo(ctx, s, docs)
+		}(i, s)
 	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			log.Errorf("Batch with %d users not written to %s, leaving their offsets open. Err: %v", len(docs), p.sinks[i].Name(), err)
+			return
+		}
+	}
+
+	for i, doc := range docs {
+		var reasons []string
+		for _, rejected := range rejections {
+			if rejected[i] != "" {
+				reasons = append(reasons, rejected[i])
+			}
+		}
+
+		if len(reasons) > 0 {
+			if p.deadLetter(ctx, doc, strings.Join(reasons, "; ")) {
+				doc.Done()
+			}
+			continue
+		}
+
+		doc.Done()
+		p.stats.Acknowledged(doc.msg)
+	}
+}
+
+// deadLetter publishes the message of the document to the dead-letter topic, retrying until it's published.
+// Returns false when the context is cancelled before the message is published.
+func (p *Indexer) deadLetter(ctx context.Context, doc *document, reason string) bool {
+	log.Errorf("can't %s user %s, sending it to dead-letter topic. Reason: %s", doc.ev.op, doc.ev.id, reason)
+	for attempt := 1; ; attempt++ {
+		err := p.dlq.Publish(doc.msg, reason)
+		if err == nil {
+			return true
+		}
+		log.Errorf("can't dead-letter user %s, retrying. Err: %v", doc.ev.id, err)
+		if !sleepBackoff(ctx, attempt) {
+			return false
+		}
+	}
+}
+
+// streamUsers consumes users until the context is cancelled. Returned function waits until consumption stops.
+func (p *Indexer) streamUsers(ctx context.Context) func() {
+	consumer := p.consumer
 
 	wg := &sync.WaitGroup{}
-	wg.Add(1)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		for {
-			if err := p.kafkaConsumer.Consume(ctx, topics, &consumer); err != nil {
+			topics, err := p.subscription.Resolve(p.kafkaClient)
+			if err != nil {
+				log.Errorf("Can't resolve topics, retrying. Err: %v", err)
+				select {
+				case <-time.After(DefaultTopicRefreshInterval / 6):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			log.Infof("Consuming topics %v as group '%s'", topics, p.stats.group)
+
+			// session is restarted by the admin API to apply new offsets and when topics matching the pattern change
+			session, restart := context.WithCancel(ctx)
+			consumer.setRestart(restart)
+			err = p.kafkaConsumer.Consume(session, topics, consumer)
+			restart()
+			if err != nil {
 				log.Panicf("Error from consumer: %v", err)
 			}
 			// check if context was cancelled, signaling that the consumer should stop
 			if ctx.Err() != nil {
 				return
 			}
-			consumer.ready = make(chan bool)
 		}
 	}()
 
-	<-consumer.ready // Await till the consumer has been set up
-	log.Println("Sarama consumer up and running!...")
+	go func() {
+		defer wg.Done()
+		refresh := time.NewTicker(DefaultTopicRefreshInterval)
+		defer refresh.Stop()
 
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+		for {
+			select {
+			case <-refresh.C:
+				if p.subscription.Changed(p.kafkaClient) {
+					log.Infof("Topics matching the pattern changed, restarting the session")
+					consumer.mu.Lock()
+					restart := consumer.restart
+					consumer.mu.Unlock()
+					if restart != nil {
+						restart()
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	go func() {
-		select {
-		case <-ctx.Done():
-			log.Println("terminating: context cancelled")
-		case <-sigterm:
-			log.Println("terminating: via signal")
-		}
-		cancel()
-		wg.Wait()
-		if err := p.kafkaConsumer.Close(); err != nil {
-			log.Panicf("Error closing client: %v", err)
+		defer wg.Done()
+		refresh := time.NewTicker(DefaultLagRefreshInterval)
+		defer refresh.Stop()
+
+		for {
+			select {
+			case <-refresh.C:
+				p.stats.Refresh(p.kafkaClient)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	return out
+	return wg.Wait
+}
+
+// decodeUser unmarshals the user from Kafka message.
+func decodeUser(msg *sarama.ConsumerMessage) (models.User, error) {
+	var user models.User
+	if err := json.Unmarshal(msg.Value, &user); err != nil {
+		return user, err
+	}
+
+	if user.Dob != nil && *user.Dob == "0000-00-00" {
+		user.Dob = nil
+	}
+
+	return user, nil
 }
 
 // Consumer represents a Sarama consumer group consumer
 type Consumer struct {
 	counter     int
-	out         chan models.User
-	ready       chan bool
+	member      int32
+	router      *router
+	dlq         *deadLetters
+	decoder     decoder
+	pause       *pauser
+	stats       *stats
 	received    *prometheus.CounterVec
 	receivedErr *prometheus.CounterVec
+
+	mu      sync.Mutex
+	reset   *offsetReset
+	restart context.CancelFunc
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// stop prevents claims from passing further messages. Session is kept until it's cancelled.
+func (consumer *Consumer) stop() {
+	consumer.stopOnce.Do(func() { close(consumer.stopped) })
+}
+
+func (consumer *Consumer) setRestart(restart context.CancelFunc) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+
+	consumer.restart = restart
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	// Mark the consumer as ready
-	close(consumer.ready)
+func (consumer *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	consumer.mu.Lock()
+	reset := consumer.reset
+	consumer.reset = nil
+	consumer.mu.Unlock()
+
+	if reset != nil {
+		reset.apply(session)
+	}
+	consumer.stats.Assign(session)
+
+	atomic.StoreInt32(&consumer.member, 1)
+
+	log.Infof("Sarama consumer up and running! Claims: %v", session.Claims())
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
 func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	atomic.StoreInt32(&consumer.member, 0)
+	consumer.stats.Revoke()
 	return nil
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
+// Offsets are marked by the tracker once users are indexed, so messages are delivered at least once.
 func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tracker := newOffsetTracker(session, claim.Topic(), claim.Partition(), consumer.stats)
+	consumer.stats.Claimed(claim.Topic(), claim.Partition(), claim.InitialOffset())
+
 	for msg := range claim.Messages() {
+		select {
+		case <-consumer.stopped:
+			<-session.Context().Done()
+			return nil
+		default:
+		}
+
+		select {
+		case <-consumer.pause.Resumed():
+		case <-consumer.stopped:
+			<-session.Context().Done()
+			return nil
+		case <-session.Context().Done():
+			return nil
+		}
+
 		log.Infof("received message: %s", string(msg.Value))
+		tracker.Track(msg.Offset)
+		consumer.stats.Received(msg, claim.HighWaterMarkOffset())
 
-		var user models.User
-		if err := json.Unmarshal(msg.Value, &user); err != nil {
+		ev, err := consumer.decoder.Decode(msg)
+		if err != nil {
 			consumer.receivedErr.WithLabelValues(msg.Topic).Inc()
-			session.MarkMessage(msg, fmt.Sprintf("can't unmarshal data from queue. err: %s", err.Error()))
-			log.Error("can't unmarshal data from queue", err)
+			log.Error("can't decode data from queue", err)
+
+			reason := fmt.Sprintf("can't decode data from queue. err: %s", err)
+			if !consumer.deadLetter(session.Context(), msg, reason) {
+				// offset stays open, so the message is consumed again by the next session
+				return nil
+			}
+			tracker.Done(msg.Offset)
 			continue
 		}
 
-		if *user.Dob == "0000-00-00" {
-			user.Dob = nil
+		select {
+		case consumer.router.Route(msg) <- &document{ev: ev, msg: msg, tracker: tracker}:
+		case <-session.Context().Done():
+			return nil
 		}
 
-		consumer.out <- user
-
-		session.MarkMessage(msg, "")
-
 		consumer.counter++
 		consumer.received.WithLabelValues(msg.Topic).Inc()
 
 		if consumer.counter%1000 == 0 {
-			log.Infof("received %n messages from Kafka", consumer.counter)
+			log.Infof("received %d messages from Kafka", consumer.counter)
 		}
 	}
 
 	return nil
 }
+
+// deadLetter publishes the message which can't be decoded to the dead-letter topic, retrying until it's published.
+// Returns false when the session ends before the message is published.
+func (consumer *Consumer) deadLetter(ctx context.Context, msg *sarama.ConsumerMessage, reason string) bool {
+	for attempt := 1; ; attempt++ {
+		err := consumer.dlq.Publish(msg, reason)
+		if err == nil {
+			return true
+		}
+		log.Errorf("can't dead-letter message %s/%d/%d, retrying. Err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSink answers calls with scripted results and records ids sent in each call.
+type fakeSink struct {
+	// results returns outcome of the call with given number, starting at 0.
+	results func(call int, ops []sink.Operation) ([]error, error)
+	calls   []string
+	flushes int
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Write(ctx context.Context, ops []sink.Operation) ([]error, error) {
+	return s.call("write", ops)
+}
+
+func (s *fakeSink) Delete(ctx context.Context, ops []sink.Operation) ([]error, error) {
+	return s.call("delete", ops)
+}
+
+func (s *fakeSink) Flush(ctx context.Context) error {
+	s.flushes++
+	return nil
+}
+
+func (s *fakeSink) Health(ctx context.Context) error { return nil }
+
+func (s *fakeSink) Close() error { return nil }
+
+func (s *fakeSink) call(name string, ops []sink.Operation) ([]error, error) {
+	ids := make([]string, len(ops))
+	for i, op := range ops {
+		ids[i] = op.ID
+	}
+	s.calls = append(s.calls, name+" "+strings.Join(ids, ","))
+
+	if s.results == nil {
+		return make([]error, len(ops)), nil
+	}
+
+	return s.results(len(s.calls)-1, ops)
+}
+
+// failing returns results where operations on given ids fail with err in the first call only.
+func failing(err error, ids ...string) func(int, []sink.Operation) ([]error, error) {
+	return func(call int, ops []sink.Operation) ([]error, error) {
+		errs := make([]error, len(ops))
+		for i, op := range ops {
+			for _, id := range ids {
+				if op.ID == id && call == 0 {
+					errs[i] = err
+				}
+			}
+		}
+		return errs, nil
+	}
+}
+
+// newTestWriter creates bulkWriter with unregistered metrics. Zero max retries means the default.
+func newTestWriter(maxRetries int) *bulkWriter {
+	if maxRetries <= 0 {
+		maxRetries = DefaultBulkMaxRetries
+	}
+
+	return &bulkWriter{
+		maxRetries: maxRetries,
+		duration:   prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"sink"}),
+		bulk:       newBulkMetrics(),
+		indexed:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "indexed"}, []string{"index"}),
+		indexedErr: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "indexed_err"}, []string{"index"}),
+		conflicts:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "conflicts"}, []string{"index"}),
+	}
+}
+
+// newTestDocs creates documents with given operations. Users are numbered from 1 unless ids are given.
+func newTestDocs(ops []string, ids []string) []*document {
+	docs := make([]*document, len(ops))
+	for i, op := range ops {
+		id := string(rune('1' + i))
+		if ids != nil {
+			id = ids[i]
+		}
+		docs[i] = &document{ev: event{op: op, id: id}, msg: &sarama.ConsumerMessage{}}
+	}
+
+	return docs
+}
+
+func TestWriteTo(t *testing.T) {
+	errTemporary := sink.Temporary(errors.New("rejected execution"))
+	errPermanent := errors.New("mapper_parsing_exception")
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		ops        []string
+		ids        []string
+		results    func(int, []sink.Operation) ([]error, error)
+		calls      []string
+		rejected   []string
+		indexed    float64
+		conflicts  float64
+		failed     float64
+	}{
+		{
+			name:     "all applied",
+			ops:      []string{OpIndex, OpUpdate, OpIndex},
+			calls:    []string{"write 1,2,3"},
+			rejected: []string{"", "", ""},
+			indexed:  3,
+		},
+		{
+			name:     "deletes sent separately in order",
+			ops:      []string{OpIndex, OpDelete, OpDelete, OpIndex},
+			calls:    []string{"write 1", "delete 2,3", "write 4"},
+			rejected: []string{"", "", "", ""},
+			indexed:  4,
+		},
+		{
+			name:     "temporary rejection retried",
+			ops:      []string{OpIndex, OpIndex, OpIndex},
+			results:  failing(errTemporary, "2"),
+			calls:    []string{"write 1,2,3", "write 2"},
+			rejected: []string{"", "", ""},
+			indexed:  3,
+		},
+		{
+			name:       "temporary rejection out of retries",
+			maxRetries: 1,
+			ops:        []string{OpIndex, OpIndex},
+			results: func(call int, ops []sink.Operation) ([]error, error) {
+				return []error{errTemporary, nil}[:len(ops)], nil
+			},
+			calls:    []string{"write 1,2", "write 1"},
+			rejected: []string{"fake: rejected execution", ""},
+			indexed:  1,
+			failed:   1,
+		},
+		{
+			name:     "permanent rejection",
+			ops:      []string{OpIndex, OpUpdate},
+			results:  failing(errPermanent, "2"),
+			calls:    []string{"write 1,2"},
+			rejected: []string{"", "fake: mapper_parsing_exception"},
+			indexed:  1,
+			failed:   1,
+		},
+		{
+			name:     "later operations on the rejected user held back",
+			ops:      []string{OpIndex, OpDelete, OpIndex},
+			ids:      []string{"1", "1", "2"},
+			results:  failing(errTemporary, "1"),
+			calls:    []string{"write 1", "write 2", "write 1", "delete 1"},
+			rejected: []string{"", "", ""},
+			indexed:  3,
+		},
+		{
+			name:     "operations of the rejected user in the same call sent again",
+			ops:      []string{OpIndex, OpUpdate, OpIndex},
+			ids:      []string{"1", "1", "2"},
+			results:  failing(errTemporary, "1"),
+			calls:    []string{"write 1,1,2", "write 1,1"},
+			rejected: []string{"", "", ""},
+			indexed:  3,
+		},
+		{
+			name:      "conflict skipped",
+			ops:       []string{OpIndex, OpDelete},
+			results:   failing(sink.ErrConflict, "1"),
+			calls:     []string{"write 1", "delete 2"},
+			rejected:  []string{"", ""},
+			indexed:   1,
+			conflicts: 1,
+		},
+		{
+			name: "failed call retried",
+			ops:  []string{OpIndex, OpIndex},
+			results: func(call int, ops []sink.Operation) ([]error, error) {
+				if call == 0 {
+					return nil, errors.New("connection refused")
+				}
+				return make([]error, len(ops)), nil
+			},
+			calls:    []string{"write 1,2", "write 1,2"},
+			rejected: []string{"", ""},
+			indexed:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(tt.maxRetries)
+			s := &fakeSink{results: tt.results}
+
+			rejected, err := w.writeTo(context.Background(), s, newTestDocs(tt.ops, tt.ids))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(s.calls, tt.calls) {
+				t.Errorf("calls: got %q, want %q", s.calls, tt.calls)
+			}
+			if !reflect.DeepEqual(rejected, tt.rejected) {
+				t.Errorf("rejected: got %q, want %q", rejected, tt.rejected)
+			}
+			if s.flushes != 1 {
+				t.Errorf("got %d flushes, want 1", s.flushes)
+			}
+			if got := testutil.ToFloat64(w.indexed.WithLabelValues("fake")); got != tt.indexed {
+				t.Errorf("indexed: got %v, want %v", got, tt.indexed)
+			}
+			if got := testutil.ToFloat64(w.conflicts.WithLabelValues("fake")); got != tt.conflicts {
+				t.Errorf("conflicts: got %v, want %v", got, tt.conflicts)
+			}
+			if got := testutil.ToFloat64(w.indexedErr.WithLabelValues("fake")); got != tt.failed {
+				t.Errorf("failed: got %v, want %v", got, tt.failed)
+			}
+		})
+	}
+}
+
+func TestWriteToCancelled(t *testing.T) {
+	w := newTestWriter(0)
+	s := &fakeSink{results: func(call int, ops []sink.Operation) ([]error, error) {
+		return nil, errors.New("connection refused")
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := w.writeTo(ctx, s, newTestDocs([]string{OpIndex, OpIndex}, nil)); err == nil {
+		t.Fatal("expected error")
+	}
+	if want := []string{"write 1,2"}; !reflect.DeepEqual(s.calls, want) {
+		t.Errorf("calls: got %q, want %q", s.calls, want)
+	}
+	if s.flushes != 0 {
+		t.Errorf("got %d flushes, want 0", s.flushes)
+	}
+	if got := testutil.ToFloat64(w.indexedErr.WithLabelValues("fake")); got != 0 {
+		t.Errorf("failed: got %v, want 0", got)
+	}
+}
@@ -0,0 +1,182 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultIndexAlias is used when IndexAlias is not set in config.
+	DefaultIndexAlias = "users"
+	// DefaultIndexVersion is used when IndexVersion is not set in config. Version 4 maps id as long.
+	DefaultIndexVersion = 4
+)
+
+// indices resolves names of the read alias, write alias and versioned physical indices.
+type indices struct {
+	read    string
+	write   string
+	version int
+}
+
+func newIndices(cfg *config.Config) indices {
+	idx := indices{read: cfg.IndexAlias, write: cfg.WriteAlias, version: cfg.IndexVersion}
+	if idx.read == "" {
+		idx.read = DefaultIndexAlias
+	}
+	if idx.write == "" {
+		idx.write = idx.read + "-write"
+	}
+	if idx.version <= 0 {
+		idx.version = DefaultIndexVersion
+	}
+
+	return idx
+}
+
+// Physical returns name of the physical index of given version.
+func (idx indices) Physical(version int) string {
+	return fmt.Sprintf("%s-v%d", idx.read, version)
+}
+
+// versioned returns physical indices of the versions, leaving out indices created by routing rules.
+func (idx indices) versioned(targets []string) []string {
+	var versioned []string
+	for _, target := range targets {
+		if indexVersion(idx, target) > 0 {
+			versioned = append(versioned, target)
+		}
+	}
+
+	return versioned
+}
+
+// resolveAlias returns indices pointed by the alias. When there is no such alias but the index
+// with the same name exists, it's returned as legacy, not versioned index.
+func resolveAlias(ctx context.Context, client *elastic.Client, alias string) (targets []string, legacy bool, err error) {
+	res, err := client.Aliases().Alias(alias).Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		return nil, false, fmt.Errorf("can't get alias %s: %w", alias, err)
+	}
+	if err == nil {
+		if targets = res.IndicesByAlias(alias); len(targets) > 0 {
+			return targets, false, nil
+		}
+	}
+
+	exists, err := client.IndexExists(alias).Do(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't check if index %s exists: %w", alias, err)
+	}
+	if exists {
+		return []string{alias}, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// createIndex creates the physical index with the mapping from models.
+func createIndex(ctx context.Context, client *elastic.Client, index string) error {
+	log.Infof("Creating index '%s'", index)
+	_, err := client.CreateIndex(index).BodyString(models.ElasticMappingString).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't create index %s: %w", index, err)
+	}
+
+	return nil
+}
+
+// ensureIndex makes sure that the write alias points to the index with up to date mapping. When there is
+// no index at all, the physical index of configured version is created with both aliases.
+func ensureIndex(ctx context.Context, client *elastic.Client, idx indices) error {
+	targets, _, err := resolveAlias(ctx, client, idx.write)
+	if err != nil {
+		return err
+	}
+	if len(targets) > 0 {
+		log.Infof("Indexing users into '%s' (%v)", idx.write, targets)
+		for _, target := range targets {
+			if err := migrateMapping(ctx, client, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	targets, legacy, err := resolveAlias(ctx, client, idx.read)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		return fmt.Errorf("index %s is not versioned, run 'indexer reindex' to migrate it to %s", idx.read, idx.Physical(idx.version))
+	}
+	if targets = idx.versioned(targets); len(targets) > 0 {
+		return fmt.Errorf("alias %s points to %v but write alias %s is missing", idx.read, targets, idx.write)
+	}
+
+	physical := idx.Physical(idx.version)
+	if err := createIndex(ctx, client, physical); err != nil {
+		return err
+	}
+
+	_, err = client.Alias().Action(
+		elastic.NewAliasAddAction(idx.read).Index(physical),
+		elastic.NewAliasAddAction(idx.write).Index(physical).IsWriteIndex(true),
+	).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't add aliases to %s: %w", physical, err)
+	}
+
+	return nil
+}
+
+// swapAliases atomically points the read and write aliases to the target index. Empty alias is left untouched.
+// Legacy index with the name of the alias is removed in the same request, as aliases can't share names with indices.
+// Indices created by routing rules keep the read alias.
+func swapAliases(ctx context.Context, client *elastic.Client, target, read, write string) error {
+	var actions []elastic.AliasAction
+	for _, alias := range []string{read, write} {
+		if alias == "" {
+			continue
+		}
+
+		current, legacy, err := resolveAlias(ctx, client, alias)
+		if err != nil {
+			return err
+		}
+		if alias == read && !legacy {
+			current = indices{read: read}.versioned(current)
+		}
+
+		for _, index := range current {
+			if index == target {
+				continue
+			}
+			if legacy {
+				log.Infof("Removing legacy index '%s'", index)
+				actions = append(actions, elastic.NewAliasRemoveIndexAction(index))
+				continue
+			}
+			actions = append(actions, elastic.NewAliasRemoveAction(alias).Index(index))
+		}
+
+		add := elastic.NewAliasAddAction(alias).Index(target)
+		if alias == write {
+			add.IsWriteIndex(true)
+		}
+		actions = append(actions, add)
+	}
+
+	if _, err := client.Alias().Action(actions...).Do(ctx); err != nil {
+		return fmt.Errorf("can't point aliases to %s: %w", target, err)
+	}
+
+	log.Infof("Aliases of '%s' swapped. Read: '%s', write: '%s'", target, read, write)
+
+	return nil
+}
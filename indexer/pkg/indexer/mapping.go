@@ -0,0 +1,234 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrIncompatibleMapping is returned when the live index can't be migrated to the desired mapping in place.
+var ErrIncompatibleMapping = errors.New("incompatible mapping, index must be rebuilt with 'indexer reindex'")
+
+// mappingDiff holds differences between the live index and models.ElasticMappingString.
+type mappingDiff struct {
+	// Added are fields missing in the live index which can be added to its mapping.
+	Added []string
+	// Changed are fields which definition differs and can't be updated in place.
+	Changed []string
+	// Settings are analysis settings which differ. They can't be updated on the open index.
+	Settings []string
+
+	additions map[string]interface{}
+}
+
+// Compatible says if the live index can be migrated by adding missing fields.
+func (d *mappingDiff) Compatible() bool {
+	return len(d.Changed) == 0 && len(d.Settings) == 0
+}
+
+// Empty says if the live index matches the desired mapping.
+func (d *mappingDiff) Empty() bool {
+	return d.Compatible() && len(d.Added) == 0
+}
+
+// migrateMapping compares the mapping and settings of the index with the desired ones.
+// Missing fields are added, while incompatible changes result in ErrIncompatibleMapping.
+func migrateMapping(ctx context.Context, client *elastic.Client, index string) error {
+	diff, err := diffMapping(ctx, client, index)
+	if err != nil {
+		return err
+	}
+
+	if diff.Empty() {
+		log.Infof("Mapping of '%s' is up to date", index)
+		return nil
+	}
+
+	for _, field := range diff.Added {
+		log.Infof("Mapping of '%s' misses field: %s", index, field)
+	}
+	for _, change := range diff.Changed {
+		log.Warnf("Mapping of '%s' differs: %s", index, change)
+	}
+	for _, change := range diff.Settings {
+		log.Warnf("Settings of '%s' differ: %s", index, change)
+	}
+
+	if !diff.Compatible() {
+		return ErrIncompatibleMapping
+	}
+
+	_, err = client.PutMapping().
+		Index(index).
+		BodyJson(map[string]interface{}{"properties": diff.additions}).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't add fields to mapping of %s: %w", index, err)
+	}
+
+	log.Infof("Added %d fields to mapping of '%s'", len(diff.Added), index)
+
+	return nil
+}
+
+func diffMapping(ctx context.Context, client *elastic.Client, index string) (*mappingDiff, error) {
+	var desired struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(models.ElasticMappingString), &desired); err != nil {
+		return nil, fmt.Errorf("can't parse desired mapping: %w", err)
+	}
+
+	mappings, err := client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get mapping of %s: %w", index, err)
+	}
+
+	settings, err := client.IndexGetSettings(index).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get settings of %s: %w", index, err)
+	}
+
+	var liveProperties, liveAnalysis map[string]interface{}
+	for _, m := range mappings {
+		liveProperties = asMap(asMap(asMap(m)["mappings"])["properties"])
+	}
+	for _, s := range settings {
+		if s != nil {
+			liveAnalysis = asMap(asMap(s.Settings["index"])["analysis"])
+		}
+	}
+
+	diff := &mappingDiff{}
+	diff.additions = diffProperties("", liveProperties, desired.Mappings.Properties, diff)
+	diff.Settings = diffValues("analysis", liveAnalysis, desired.Settings["analysis"])
+
+	return diff, nil
+}
+
+// diffProperties compares field definitions and returns definitions of fields which should be added.
+func diffProperties(path string, live, desired map[string]interface{}, diff *mappingDiff) map[string]interface{} {
+	additions := make(map[string]interface{})
+	for _, name := range sortedKeys(desired) {
+		field := name
+		if path != "" {
+			field = path + "." + name
+		}
+
+		want := asMap(desired[name])
+		have, ok := live[name]
+		if !ok {
+			diff.Added = append(diff.Added, field)
+			additions[name] = want
+			continue
+		}
+
+		for _, param := range sortedKeys(want) {
+			switch param {
+			case "properties":
+				sub := diffProperties(field, asMap(asMap(have)["properties"]), asMap(want[param]), diff)
+				if len(sub) > 0 {
+					additions[name] = map[string]interface{}{"properties": sub}
+				}
+			case "fields":
+				// new multi-field is added with the whole definition of its parent field
+				if sub := diffProperties(field, asMap(asMap(have)["fields"]), asMap(want[param]), diff); len(sub) > 0 {
+					additions[name] = want
+				}
+			default:
+				diff.Changed = append(diff.Changed, diffValues(field+"."+param, asMap(have)[param], want[param])...)
+			}
+		}
+	}
+
+	return additions
+}
+
+// diffValues compares settings or field parameters. Values are compared as strings, since Elasticsearch
+// returns settings as strings.
+func diffValues(path string, live, desired interface{}) []string {
+	live, desired = normalize(live), normalize(desired)
+
+	want, ok := desired.(map[string]interface{})
+	if !ok {
+		if !reflect.DeepEqual(live, desired) {
+			return []string{fmt.Sprintf("%s is %v, want %v", path, live, desired)}
+		}
+		return nil
+	}
+
+	have := asMap(live)
+	var diffs []string
+	for _, key := range sortedKeys(want) {
+		diffs = append(diffs, diffValues(path+"."+key, have[key], want[key])...)
+	}
+
+	return diffs
+}
+
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = normalize(v)
+		}
+		return m
+	case []interface{}:
+		if len(t) == 0 {
+			return nil
+		}
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = normalize(v)
+		}
+		return l
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// indexVersion returns the version of the physical index, or 0 when the index is not versioned.
+func indexVersion(idx indices, index string) int {
+	prefix := idx.read + "-v"
+	if !strings.HasPrefix(index, prefix) {
+		return 0
+	}
+
+	// names like users-v03 or users-v+3 are not created by the indexer
+	version, err := strconv.Atoi(strings.TrimPrefix(index, prefix))
+	if err != nil || version <= 0 || idx.Physical(version) != index {
+		return 0
+	}
+
+	return version
+}
@@ -0,0 +1,154 @@
+package indexer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffProperties(t *testing.T) {
+	tests := []struct {
+		name      string
+		live      string
+		desired   string
+		added     []string
+		changed   []string
+		additions string
+	}{
+		{
+			name:      "same mapping",
+			live:      `{"email": {"type": "keyword"}, "age": {"type": "integer"}}`,
+			desired:   `{"email": {"type": "keyword"}, "age": {"type": "integer"}}`,
+			additions: `{}`,
+		},
+		{
+			name:      "missing field",
+			live:      `{"email": {"type": "keyword"}}`,
+			desired:   `{"email": {"type": "keyword"}, "age": {"type": "integer"}}`,
+			added:     []string{"age"},
+			additions: `{"age": {"type": "integer"}}`,
+		},
+		{
+			name:      "changed type",
+			live:      `{"id": {"type": "text"}}`,
+			desired:   `{"id": {"type": "long"}}`,
+			changed:   []string{"id.type is text, want long"},
+			additions: `{}`,
+		},
+		{
+			name:      "missing nested field",
+			live:      `{"location": {"properties": {"lat": {"type": "float"}}}}`,
+			desired:   `{"location": {"properties": {"lat": {"type": "float"}, "lon": {"type": "float"}}}}`,
+			added:     []string{"location.lon"},
+			additions: `{"location": {"properties": {"lon": {"type": "float"}}}}`,
+		},
+		{
+			name:      "missing multi-field adds the whole field",
+			live:      `{"city": {"type": "text"}}`,
+			desired:   `{"city": {"type": "text", "fields": {"raw": {"type": "keyword"}}}}`,
+			added:     []string{"city.raw"},
+			additions: `{"city": {"type": "text", "fields": {"raw": {"type": "keyword"}}}}`,
+		},
+		{
+			name:      "extra live fields are ignored",
+			live:      `{"email": {"type": "keyword"}, "legacy": {"type": "text"}}`,
+			desired:   `{"email": {"type": "keyword"}}`,
+			additions: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := &mappingDiff{}
+			additions := diffProperties("", decodeMap(t, tt.live), decodeMap(t, tt.desired), diff)
+
+			if !reflect.DeepEqual(diff.Added, tt.added) {
+				t.Errorf("added: got %v, want %v", diff.Added, tt.added)
+			}
+			if !reflect.DeepEqual(diff.Changed, tt.changed) {
+				t.Errorf("changed: got %v, want %v", diff.Changed, tt.changed)
+			}
+			if want := decodeMap(t, tt.additions); !reflect.DeepEqual(additions, want) {
+				t.Errorf("additions: got %v, want %v", additions, want)
+			}
+		})
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    string
+		desired string
+		want    []string
+	}{
+		{
+			name:    "settings returned as strings",
+			live:    `{"max_ngram_diff": "10", "filter": {"ngram": {"min_gram": "2"}}}`,
+			desired: `{"max_ngram_diff": 10, "filter": {"ngram": {"min_gram": 2}}}`,
+		},
+		{
+			name:    "changed value",
+			live:    `{"filter": {"ngram": {"min_gram": "3"}}}`,
+			desired: `{"filter": {"ngram": {"min_gram": 2}}}`,
+			want:    []string{"analysis.filter.ngram.min_gram is 3, want 2"},
+		},
+		{
+			name:    "missing setting",
+			live:    `{}`,
+			desired: `{"analyzer": {"name": {"tokenizer": "standard"}}}`,
+			want:    []string{"analysis.analyzer.name.tokenizer is <nil>, want standard"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffValues("analysis", decodeMap(t, tt.live), decodeMap(t, tt.desired))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func decodeMap(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatalf("can't decode %s: %v", s, err)
+	}
+
+	return m
+}
+
+func TestIndexVersion(t *testing.T) {
+	idx := indices{read: "users", write: "users-write", version: 1}
+
+	tests := []struct {
+		index string
+		want  int
+	}{
+		{index: "users-v1", want: 1},
+		{index: "users-v12", want: 12},
+		{index: "users", want: 0},
+		{index: "users-write", want: 0},
+		{index: "users-v", want: 0},
+		{index: "users-v3-old", want: 0},
+		{index: "users-v3.1", want: 0},
+		{index: "users-v03", want: 0},
+		{index: "users-v+3", want: 0},
+		{index: "users-v-3", want: 0},
+		{index: "users-v0", want: 0},
+		{index: "2019.10-users", want: 0},
+		{index: "users-2019.10", want: 0},
+		{index: "old-users-v2", want: 0},
+		{index: "customers-v2", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := indexVersion(idx, tt.index); got != tt.want {
+			t.Errorf("indexVersion(%q): got %d, want %d", tt.index, got, tt.want)
+		}
+	}
+}
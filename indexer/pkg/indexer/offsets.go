@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetTracker marks offsets of the claimed partition only when all previous messages are processed.
+type offsetTracker struct {
+	mu        sync.Mutex
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	pending   []int64
+	done      map[int64]bool
+	stats     *stats
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession, topic string, partition int32, stats *stats) *offsetTracker {
+	return &offsetTracker{
+		session:   session,
+		stats:     stats,
+		topic:     topic,
+		partition: partition,
+		done:      make(map[int64]bool),
+	}
+}
+
+// Track registers offset of the message which processing just started. Offsets must be tracked in order.
+func (t *offsetTracker) Track(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, offset)
+}
+
+// Done marks message as processed and moves the committed offset as far as possible.
+func (t *offsetTracker) Done(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done[offset] = true
+
+	next := int64(-1)
+	for len(t.pending) > 0 && t.done[t.pending[0]] {
+		next = t.pending[0] + 1
+		delete(t.done, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+
+	if next >= 0 {
+		t.session.MarkOffset(t.topic, t.partition, next, "")
+		t.stats.Processed(t.topic, t.partition, next)
+	}
+}
+
+// document is the operation on the user read from Kafka which offset is marked once it's applied.
+type document struct {
+	ev      event
+	msg     *sarama.ConsumerMessage
+	tracker *offsetTracker
+}
+
+// Done marks the Kafka message of the document as processed.
+func (d *document) Done() {
+	d.tracker.Done(d.msg.Offset)
+}
@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// markSession records offsets marked by the tracker.
+type markSession struct {
+	sarama.ConsumerGroupSession
+	marked []int64
+}
+
+func (s *markSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked = append(s.marked, offset)
+}
+
+func TestOffsetTrackerDone(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracked []int64
+		done    []int64
+		marked  []int64
+		pending []int64
+	}{
+		{
+			name:    "in order",
+			tracked: []int64{10, 11, 12},
+			done:    []int64{10, 11, 12},
+			marked:  []int64{11, 12, 13},
+		},
+		{
+			name:    "out of order",
+			tracked: []int64{10, 11, 12},
+			done:    []int64{12, 11, 10},
+			marked:  []int64{13},
+		},
+		{
+			name:    "gap keeps later offsets",
+			tracked: []int64{10, 11, 12},
+			done:    []int64{10, 12},
+			marked:  []int64{11},
+			pending: []int64{11, 12},
+		},
+		{
+			name:    "nothing done",
+			tracked: []int64{10, 11},
+			pending: []int64{10, 11},
+		},
+		{
+			name:    "offsets with holes",
+			tracked: []int64{10, 15, 20},
+			done:    []int64{15, 10, 20},
+			marked:  []int64{16, 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &markSession{}
+			tracker := newOffsetTracker(session, "users", 0, &stats{})
+
+			for _, offset := range tt.tracked {
+				tracker.Track(offset)
+			}
+			for _, offset := range tt.done {
+				tracker.Done(offset)
+			}
+
+			if !reflect.DeepEqual(session.marked, tt.marked) {
+				t.Errorf("marked offsets: got %v, want %v", session.marked, tt.marked)
+			}
+			if len(tracker.pending) != len(tt.pending) || (len(tt.pending) > 0 && !reflect.DeepEqual(tracker.pending, tt.pending)) {
+				t.Errorf("pending offsets: got %v, want %v", tracker.pending, tt.pending)
+			}
+		})
+	}
+}
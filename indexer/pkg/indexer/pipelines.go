@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWorkerQueueSize is used when WorkerQueueSize is not set in config.
+const DefaultWorkerQueueSize = 256
+
+// Routing of the documents to workers.
+const (
+	// RoutingKey sends messages with the same key (user id) to the same worker.
+	RoutingKey = "key"
+	// RoutingPartition sends messages from the same partition to the same worker.
+	RoutingPartition = "partition"
+)
+
+// router dispatches documents to the queues of the workers. Each worker indexes its documents in order,
+// so operations on the user are applied in the order they were produced. Full queue blocks the claim.
+type router struct {
+	queues      []chan *document
+	byPartition bool
+}
+
+func newRouter(workers, queueSize int, routing string) (*router, error) {
+	if workers <= 0 {
+		workers = DefaultBulkWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultWorkerQueueSize
+	}
+
+	r := &router{}
+	switch routing {
+	case "", RoutingKey:
+	case RoutingPartition:
+		r.byPartition = true
+	default:
+		return nil, fmt.Errorf("unknown worker routing %q", routing)
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := make(chan *document, queueSize)
+		r.queues = append(r.queues, queue)
+
+		prometheus.Register(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace:   "am",
+				Subsystem:   "indexer",
+				Name:        "worker_queue_length",
+				Help:        "The number of documents waiting for the worker.",
+				ConstLabels: prometheus.Labels{"worker": strconv.Itoa(i)},
+			},
+			func() float64 { return float64(len(queue)) },
+		))
+	}
+
+	return r, nil
+}
+
+// Route returns the queue of the worker responsible for the message.
+// Messages without key are routed by partition.
+func (r *router) Route(msg *sarama.ConsumerMessage) chan *document {
+	if len(r.queues) == 1 {
+		return r.queues[0]
+	}
+
+	h := fnv.New32a()
+	if r.byPartition || len(msg.Key) == 0 {
+		h.Write([]byte(msg.Topic))
+		h.Write([]byte(strconv.Itoa(int(msg.Partition))))
+	} else {
+		h.Write(msg.Key)
+	}
+
+	return r.queues[h.Sum32()%uint32(len(r.queues))]
+}
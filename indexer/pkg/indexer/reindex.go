@@ -0,0 +1,266 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sources of the reindex.
+const (
+	ReindexFromIndex = "index"
+	ReindexFromKafka = "kafka"
+)
+
+// Reindex builds the physical index of given version and points aliases to it, so the mapping can be changed
+// without downtime.
+//
+// When the source is the current index, write alias is moved to the new index first, so live writes are not lost,
+// and documents are copied only when they don't exist in the new index yet. Then the read alias is swapped.
+//
+// When the source is Kafka, the topic is replayed into the new index up to its current end, both aliases are swapped
+// together and messages which arrived during the replay are indexed once more.
+func Reindex(cfg *config.Config, from string, version int) error {
+	ctx := context.Background()
+	idx := newIndices(cfg)
+	if version <= 0 {
+		version = idx.version
+	}
+	target := idx.Physical(version)
+
+	decoder, err := newDecoder(cfg)
+	if err != nil {
+		return err
+	}
+
+	client, err := esclient.New(cfg.Elastic)
+	if err != nil {
+		return err
+	}
+
+	exists, err := client.IndexExists(target).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't check if index %s exists: %w", target, err)
+	}
+	if exists {
+		return fmt.Errorf("index %s already exists", target)
+	}
+
+	switch from {
+	case ReindexFromIndex:
+		return reindexFromIndex(ctx, client, idx, target, decoder.versions)
+	case ReindexFromKafka:
+		return reindexFromKafka(ctx, cfg, client, idx, target, decoder)
+	default:
+		return fmt.Errorf("unknown reindex source %q", from)
+	}
+}
+
+func reindexFromIndex(ctx context.Context, client *elastic.Client, idx indices, target string, versions versioning) error {
+	current, legacy, err := resolveAlias(ctx, client, idx.read)
+	if err != nil {
+		return err
+	}
+	if !legacy {
+		current = idx.versioned(current)
+	}
+	if len(current) == 0 {
+		return fmt.Errorf("there is no index behind %s to reindex from", idx.read)
+	}
+
+	if err := createIndex(ctx, client, target); err != nil {
+		return err
+	}
+
+	if err := swapAliases(ctx, client, target, "", idx.write); err != nil {
+		return err
+	}
+
+	// versioned documents are copied only when they are newer, others only when they are missing
+	dest := elastic.NewReindexDestination().Index(target).OpType("create")
+	if versions.source != VersionNone {
+		dest = elastic.NewReindexDestination().Index(target).VersionType("external")
+	}
+
+	log.Infof("Reindexing %v into '%s'", current, target)
+	res, err := client.Reindex().
+		Source(elastic.NewReindexSource().Index(current...)).
+		Destination(dest).
+		ProceedOnVersionConflict().
+		WaitForCompletion(true).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't reindex %v into %s: %w", current, target, err)
+	}
+	if len(res.Failures) > 0 {
+		return fmt.Errorf("reindex of %v into %s failed for %d documents", current, target, len(res.Failures))
+	}
+	log.Infof("Reindexed %d documents into '%s'. Skipped as already indexed: %d", res.Created, target, res.VersionConflicts)
+
+	return swapAliases(ctx, client, target, idx.read, "")
+}
+
+func reindexFromKafka(ctx context.Context, cfg *config.Config, client *elastic.Client, idx indices, target string, decoder decoder) error {
+	kafkaCfg, err := kafkaConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	kafkaClient, err := sarama.NewClient(cfg.Brokers, kafkaCfg)
+	if err != nil {
+		return fmt.Errorf("can't create kafka client: %w", err)
+	}
+	defer kafkaClient.Close()
+
+	subscription, err := newSubscription(cfg)
+	if err != nil {
+		return err
+	}
+
+	topics, err := subscription.Resolve(kafkaClient)
+	if err != nil {
+		return err
+	}
+
+	if err := createIndex(ctx, client, target); err != nil {
+		return err
+	}
+
+	w := newBulkWriter(cfg)
+	end := make(map[string]map[int32]int64)
+	for _, topic := range topics {
+		if end[topic], err = newestOffsets(kafkaClient, topic); err != nil {
+			return err
+		}
+
+		indexed, err := replayIntoIndex(ctx, kafkaClient, w, client, decoder, topic, target, make(map[int32]int64), end[topic])
+		if err != nil {
+			return err
+		}
+		log.Infof("Indexed %d users from '%s' into '%s'", indexed, topic, target)
+	}
+
+	if err := swapAliases(ctx, client, target, idx.read, idx.write); err != nil {
+		return err
+	}
+
+	// messages which arrived during the replay went to the previous index
+	for _, topic := range topics {
+		latest, err := newestOffsets(kafkaClient, topic)
+		if err != nil {
+			return err
+		}
+
+		indexed, err := replayIntoIndex(ctx, kafkaClient, w, client, decoder, topic, target, end[topic], latest)
+		if err != nil {
+			return err
+		}
+		log.Infof("Indexed %d users from '%s' which arrived during the reindex into '%s'", indexed, topic, target)
+	}
+
+	return nil
+}
+
+func newestOffsets(client sarama.Client, topic string) (map[int32]int64, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("can't get partitions of %s: %w", topic, err)
+	}
+
+	offsets := make(map[int32]int64)
+	for _, partition := range partitions {
+		offset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("can't get newest offset of %s/%d: %w", topic, partition, err)
+		}
+		offsets[partition] = offset
+	}
+
+	return offsets, nil
+}
+
+// replayIntoIndex indexes messages of the topic between given offsets. Missing start offset means the oldest one.
+// It stops when the context is cancelled.
+func replayIntoIndex(ctx context.Context, kafkaClient sarama.Client, w *bulkWriter, client *elastic.Client, decoder decoder, topic, index string, start, end map[int32]int64) (int, error) {
+	consumer, err := sarama.NewConsumerFromClient(kafkaClient)
+	if err != nil {
+		return 0, fmt.Errorf("can't create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	l := &loader{w: w, s: sink.NewElasticsearch(client, index)}
+	for partition, last := range end {
+		first, ok := start[partition]
+		if !ok {
+			if first, err = kafkaClient.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+				return l.written, fmt.Errorf("can't get oldest offset of %s/%d: %w", topic, partition, err)
+			}
+		}
+
+		if err := replayPartitionInto(ctx, consumer, l, decoder, topic, partition, first, last); err != nil {
+			return l.written, err
+		}
+	}
+	if err := l.Flush(ctx); err != nil {
+		return l.written, err
+	}
+
+	if l.rejected > 0 {
+		return l.written, fmt.Errorf("%d users rejected by %s", l.rejected, index)
+	}
+
+	return l.written, nil
+}
+
+func replayPartitionInto(ctx context.Context, consumer sarama.Consumer, l *loader, decoder decoder, topic string, partition int32, first, last int64) error {
+	if first >= last {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, first)
+	if err != nil {
+		return fmt.Errorf("can't consume %s/%d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+
+	offset := first
+	for {
+		select {
+		case msg := <-pc.Messages():
+			offset = msg.Offset
+			// users routed by rules are kept only in their indices
+			ev, err := decoder.Decode(msg)
+			if err != nil {
+				log.Errorf("can't index message %s/%d/%d. Err: %v", topic, partition, msg.Offset, err)
+			} else if ev.index == "" {
+				if err := l.Add(ctx, &document{ev: ev, msg: msg}); err != nil {
+					return err
+				}
+			}
+
+			if msg.Offset+1 >= last {
+				return nil
+			}
+		case <-progress.C:
+			log.Infof("Reindexing %s/%d. Offset: %d/%d", topic, partition, offset, last)
+		case err := <-pc.Errors():
+			if err == nil {
+				return errors.New("partition consumer closed")
+			}
+			return fmt.Errorf("can't consume %s/%d: %w", topic, partition, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
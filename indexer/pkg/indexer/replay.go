@@ -0,0 +1,160 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// replayGroup is the group which offsets track messages already replayed from the dead-letter topic.
+const replayGroup = "indexer-dlq-replay"
+
+// Replay re-injects messages from the dead-letter topic into their source topics.
+// Only messages published before the replay started are re-injected. Progress is committed,
+// so the next replay starts where the previous one stopped.
+func Replay(cfg *config.Config) (int, error) {
+	topic := cfg.DLQTopic
+	if topic == "" {
+		topic = DefaultDLQTopic
+	}
+
+	kafkaCfg, err := kafkaConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, kafkaCfg)
+	if err != nil {
+		return 0, fmt.Errorf("can't create kafka client: %w", err)
+	}
+	defer client.Close()
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return 0, fmt.Errorf("can't create producer: %w", err)
+	}
+	defer producer.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return 0, fmt.Errorf("can't create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(replayGroup, client)
+	if err != nil {
+		return 0, fmt.Errorf("can't create offset manager: %w", err)
+	}
+	defer offsets.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return 0, fmt.Errorf("can't get partitions of %s: %w", topic, err)
+	}
+
+	var replayed int
+	for _, partition := range partitions {
+		n, err := replayPartition(client, consumer, offsets, producer, cfg.Topic, topic, partition)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+func replayPartition(client sarama.Client, consumer sarama.Consumer, offsets sarama.OffsetManager,
+	producer sarama.SyncProducer, defaultTopic, topic string, partition int32) (int, error) {
+	high, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("can't get newest offset of %s/%d: %w", topic, partition, err)
+	}
+
+	pom, err := offsets.ManagePartition(topic, partition)
+	if err != nil {
+		return 0, fmt.Errorf("can't manage offsets of %s/%d: %w", topic, partition, err)
+	}
+	defer pom.Close()
+
+	next, _ := pom.NextOffset()
+	if next < 0 {
+		if next, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+			return 0, fmt.Errorf("can't get oldest offset of %s/%d: %w", topic, partition, err)
+		}
+	}
+
+	if next >= high {
+		return 0, nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, next)
+	if err != nil {
+		return 0, fmt.Errorf("can't consume %s/%d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+
+	var replayed int
+	for {
+		select {
+		case msg := <-pc.Messages():
+			_, _, err := producer.SendMessage(replayMessage(msg, defaultTopic))
+			if err != nil {
+				return replayed, fmt.Errorf("can't replay message %s/%d/%d: %w", topic, partition, msg.Offset, err)
+			}
+
+			pom.MarkOffset(msg.Offset+1, "")
+			replayed++
+
+			if msg.Offset+1 >= high {
+				log.Infof("replayed %d messages from %s/%d", replayed, topic, partition)
+				return replayed, nil
+			}
+		case err := <-pc.Errors():
+			return replayed, fmt.Errorf("can't consume %s/%d: %w", topic, partition, err)
+		}
+	}
+}
+
+// replayMessage recreates the original message from the dead-letter one with its topic, timestamp and headers.
+func replayMessage(msg *sarama.ConsumerMessage, defaultTopic string) *sarama.ProducerMessage {
+	target := headerValue(msg, HeaderTopic)
+	if target == "" {
+		target = defaultTopic
+	}
+
+	replay := &sarama.ProducerMessage{
+		Topic: target,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: value(msg),
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, headerValue(msg, HeaderTimestamp)); err == nil && !timestamp.IsZero() {
+		replay.Timestamp = timestamp
+	}
+
+	for _, h := range msg.Headers {
+		if key := string(h.Key); strings.HasPrefix(key, HeaderOriginalPrefix) {
+			replay.Headers = append(replay.Headers, sarama.RecordHeader{Key: []byte(strings.TrimPrefix(key, HeaderOriginalPrefix)), Value: h.Value})
+		}
+	}
+	// messages dead-lettered by older versions carry only the operation
+	if op := headerValue(msg, HeaderOp); op != "" && len(replay.Headers) == 0 {
+		replay.Headers = []sarama.RecordHeader{header(HeaderOp, op)}
+	}
+
+	return replay
+}
+
+func headerValue(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
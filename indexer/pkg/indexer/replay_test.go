@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// consumed returns the message as it's read from the topic.
+func consumed(msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	out := &sarama.ConsumerMessage{Topic: msg.Topic, Timestamp: msg.Timestamp}
+	out.Key, _ = msg.Key.Encode()
+	if msg.Value != nil {
+		out.Value, _ = msg.Value.Encode()
+	}
+	for i := range msg.Headers {
+		out.Headers = append(out.Headers, &msg.Headers[i])
+	}
+
+	return out
+}
+
+func TestReplayMessage(t *testing.T) {
+	produced := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		msg     *sarama.ConsumerMessage
+		topic   string
+		headers []sarama.RecordHeader
+		time    time.Time
+	}{
+		{
+			name: "headers and timestamp restored",
+			msg: &sarama.ConsumerMessage{
+				Topic:     "users",
+				Key:       []byte("1"),
+				Value:     []byte(`{"id": 1}`),
+				Timestamp: produced,
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte(HeaderOp), Value: []byte(OpUpdate)},
+					{Key: []byte("source"), Value: []byte("crm")},
+					{Key: []byte(HeaderTopic), Value: []byte("other")},
+				},
+			},
+			topic: "users",
+			headers: []sarama.RecordHeader{
+				header(HeaderOp, OpUpdate),
+				header("source", "crm"),
+				header(HeaderTopic, "other"),
+			},
+			time: produced,
+		},
+		{
+			name:  "tombstone without headers and timestamp",
+			msg:   &sarama.ConsumerMessage{Topic: "users-eu", Key: []byte("2")},
+			topic: "users-eu",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay := replayMessage(consumed(deadLetter(DefaultDLQTopic, tt.msg, "can't index")), "default")
+
+			if replay.Topic != tt.topic {
+				t.Errorf("topic: got %s, want %s", replay.Topic, tt.topic)
+			}
+			if key, _ := replay.Key.Encode(); string(key) != string(tt.msg.Key) {
+				t.Errorf("key: got %s, want %s", key, tt.msg.Key)
+			}
+			if (replay.Value == nil) != (tt.msg.Value == nil) {
+				t.Errorf("value: got %v, want %s", replay.Value, tt.msg.Value)
+			}
+			if !replay.Timestamp.Equal(tt.time) {
+				t.Errorf("timestamp: got %v, want %v", replay.Timestamp, tt.time)
+			}
+			if !reflect.DeepEqual(replay.Headers, tt.headers) {
+				t.Errorf("headers: got %q, want %q", replay.Headers, tt.headers)
+			}
+		})
+	}
+}
+
+func TestReplayMessageOfOlderVersion(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Key: []byte("1"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderError), Value: []byte("can't index")},
+			{Key: []byte(HeaderOp), Value: []byte(OpDelete)},
+		},
+	}
+
+	replay := replayMessage(msg, "users")
+	if want := []sarama.RecordHeader{header(HeaderOp, OpDelete)}; !reflect.DeepEqual(replay.Headers, want) {
+		t.Errorf("headers: got %q, want %q", replay.Headers, want)
+	}
+	if replay.Topic != "users" || !replay.Timestamp.IsZero() {
+		t.Errorf("got topic %s and timestamp %v, want users without timestamp", replay.Topic, replay.Timestamp)
+	}
+}
@@ -0,0 +1,265 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// Placeholders of the index name in routing rules.
+const (
+	// PlaceholderValue is replaced with the value of the field or header of the rule.
+	PlaceholderValue = "{value}"
+	// PlaceholderMonth is replaced with the month the message was produced to Kafka, e.g. 2019.10.
+	PlaceholderMonth = "{month}"
+)
+
+// indexRoute sends users to the index when the field of the user or the header of Kafka message has one of the values.
+type indexRoute struct {
+	field  string
+	header string
+	values map[string]bool
+	index  string
+}
+
+// routing picks the target index of the user by the first matching rule. Users not matched by any rule
+// are written to the write alias of the versioned index.
+type routing struct {
+	routes []indexRoute
+	fields bool
+}
+
+func newRouting(cfg *config.Config) (routing, error) {
+	idx := newIndices(cfg)
+
+	var r routing
+	for i, rule := range cfg.IndexRoutes {
+		route := indexRoute{
+			field:  rule.Field,
+			header: rule.Header,
+			index:  strings.ToLower(rule.Index),
+		}
+
+		switch {
+		case route.index == "":
+			return r, fmt.Errorf("index of routing rule %d is missing", i+1)
+		case route.field != "" && route.header != "":
+			return r, fmt.Errorf("routing rule %d can use either field or header", i+1)
+		case route.field == "" && route.header == "" && (len(rule.Values) > 0 || strings.Contains(route.index, PlaceholderValue)):
+			return r, fmt.Errorf("routing rule %d matches values but has no field or header", i+1)
+		}
+
+		if len(rule.Values) > 0 {
+			route.values = make(map[string]bool)
+			for _, v := range rule.Values {
+				route.values[indexSafe(v)] = true
+			}
+		}
+
+		pattern := route.Pattern()
+		for _, name := range []string{idx.read, idx.write} {
+			if ok, err := path.Match(pattern, name); err != nil || ok {
+				return r, fmt.Errorf("index %s of routing rule %d overlaps with %s", rule.Index, i+1, name)
+			}
+		}
+		if route.versioned(idx) {
+			return r, fmt.Errorf("index %s of routing rule %d overlaps with versioned indices %s-v<n>", rule.Index, i+1, idx.read)
+		}
+
+		r.fields = r.fields || route.field != ""
+		r.routes = append(r.routes, route)
+	}
+
+	return r, nil
+}
+
+// Index returns the index of the user, empty when no rule matches. Partial updates and deletes are matched
+// only by fields they carry, so rules by fields of the user should be used for users which are never updated.
+func (r routing) Index(msg *sarama.ConsumerMessage, ev event) string {
+	if len(r.routes) == 0 {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if r.fields {
+		fields = ev.partial
+		if ev.op == OpIndex {
+			fields = userFields(ev.user)
+		}
+	}
+
+	for _, route := range r.routes {
+		var value string
+		switch {
+		case route.field != "":
+			if v, ok := fields[route.field]; ok && v != nil {
+				value = indexSafe(fmt.Sprint(v))
+			}
+		case route.header != "":
+			value = indexSafe(headerValue(msg, route.header))
+		}
+
+		if (route.field != "" || route.header != "") && value == "" {
+			continue
+		}
+		if route.values != nil && !route.values[value] {
+			continue
+		}
+
+		produced := msg.Timestamp
+		if produced.IsZero() {
+			produced = time.Now()
+		}
+
+		index := strings.Replace(route.index, PlaceholderValue, value, -1)
+		return strings.Replace(index, PlaceholderMonth, produced.UTC().Format("2006.01"), -1)
+	}
+
+	return ""
+}
+
+// Patterns returns patterns of names of all indices the rules can route users to.
+func (r routing) Patterns() []string {
+	var patterns []string
+	for _, route := range r.routes {
+		patterns = append(patterns, route.Pattern())
+	}
+
+	return patterns
+}
+
+// Pattern returns the pattern of names of indices the rule can route users to.
+func (route indexRoute) Pattern() string {
+	pattern := strings.Replace(route.index, PlaceholderValue, "*", -1)
+	return strings.Replace(pattern, PlaceholderMonth, "*", -1)
+}
+
+// versioned says if the rule can route users to the physical index of any version, e.g. users-v2 by the index
+// users-{value} and the value v2. Such users would be taken for the version by reindex and restore.
+func (route indexRoute) versioned(idx indices) bool {
+	names := []string{route.index}
+	if route.values != nil {
+		names = names[:0]
+		for value := range route.values {
+			names = append(names, strings.Replace(route.index, PlaceholderValue, value, -1))
+		}
+	}
+
+	for _, name := range names {
+		// '*' and '#' are not allowed in index names, so they stand for any value and a digit of the month
+		glob := strings.Replace(name, PlaceholderValue, "*", -1)
+		if matchesVersion(idx, strings.Replace(glob, PlaceholderMonth, "####.##", -1)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesVersion says if the glob matches the name of any physical index returned by indices.Physical.
+// Names are matched by tracking all possible states - the number of matched characters of '<read>-v'
+// or the version, when at least one digit of the version is matched.
+func matchesVersion(idx indices, glob string) bool {
+	prefix := idx.read + "-v"
+	version := len(prefix) + 1
+	step := func(state int, c byte) int {
+		switch {
+		case state < len(prefix) && prefix[state] == c:
+			return state + 1
+		case state == len(prefix) && c >= '1' && c <= '9', state == version && c >= '0' && c <= '9':
+			return version
+		}
+		return -1
+	}
+
+	states := map[int]bool{0: true}
+	for i := 0; i < len(glob) && len(states) > 0; i++ {
+		next := make(map[int]bool)
+		for state := range states {
+			switch glob[i] {
+			case '*':
+				for s := state + 1; s <= len(prefix); s++ {
+					next[s] = true
+				}
+				next[version] = true
+			case '#':
+				for c := byte('0'); c <= '9'; c++ {
+					if s := step(state, c); s >= 0 {
+						next[s] = true
+					}
+				}
+			default:
+				if s := step(state, glob[i]); s >= 0 {
+					next[s] = true
+				}
+			}
+		}
+		states = next
+	}
+
+	return states[version]
+}
+
+// userFields returns fields of the user as they are indexed.
+func userFields(user models.User) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if data, err := json.Marshal(user); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+
+	return fields
+}
+
+// indexSafe lowercases the value and replaces characters not allowed in index names.
+func indexSafe(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/*?"<>| ,#:`, r) {
+			return '-'
+		}
+		return r
+	}, value)
+
+	return strings.TrimLeft(value, "-_+.")
+}
+
+// ensureRoutedIndices registers the index template with the mapping from models, so indices created by routing
+// rules get the right mapping and join the read alias. Mapping of already existing routed indices is migrated.
+func ensureRoutedIndices(ctx context.Context, client *elastic.Client, idx indices, r routing) error {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(models.ElasticMappingString), &body); err != nil {
+		return fmt.Errorf("can't decode mapping: %w", err)
+	}
+	body["index_patterns"] = r.Patterns()
+	body["aliases"] = map[string]interface{}{idx.read: map[string]interface{}{}}
+
+	if _, err := client.IndexPutTemplate(idx.read).BodyJson(body).Do(ctx); err != nil {
+		return fmt.Errorf("can't put index template %s: %w", idx.read, err)
+	}
+	log.Infof("Index template '%s' registered for %v", idx.read, r.Patterns())
+
+	targets, legacy, err := resolveAlias(ctx, client, idx.read)
+	if err != nil || legacy {
+		return err
+	}
+
+	for _, target := range targets {
+		if indexVersion(idx, target) > 0 {
+			continue
+		}
+		if err := migrateMapping(ctx, client, target); err != nil {
+			return fmt.Errorf("can't migrate mapping of %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+func TestNewRouting(t *testing.T) {
+	tests := []struct {
+		name     string
+		alias    string
+		routes   []config.IndexRoute
+		patterns []string
+		wantErr  bool
+	}{
+		{
+			name: "no rules",
+		},
+		{
+			name: "field, header and month",
+			routes: []config.IndexRoute{
+				{Field: "country", Index: "Users-Country-{value}"},
+				{Header: "source", Values: []string{"CRM"}, Index: "users-source-{value}"},
+				{Index: "users-monthly-{month}"},
+			},
+			patterns: []string{"users-country-*", "users-source-*", "users-monthly-*"},
+		},
+		{
+			name:    "missing index",
+			routes:  []config.IndexRoute{{Field: "country"}},
+			wantErr: true,
+		},
+		{
+			name:    "field and header",
+			routes:  []config.IndexRoute{{Field: "country", Header: "source", Index: "users-{value}-x"}},
+			wantErr: true,
+		},
+		{
+			name:    "value without field",
+			routes:  []config.IndexRoute{{Index: "users-x-{value}"}},
+			wantErr: true,
+		},
+		{
+			name:    "values without field",
+			routes:  []config.IndexRoute{{Values: []string{"1"}, Index: "users-x"}},
+			wantErr: true,
+		},
+		{
+			name:    "overlaps with the versioned index",
+			routes:  []config.IndexRoute{{Field: "country", Index: "users-{value}"}},
+			wantErr: true,
+		},
+		{
+			name:    "overlaps with the read alias",
+			routes:  []config.IndexRoute{{Index: "users"}},
+			wantErr: true,
+		},
+		{
+			name:    "value can be any version",
+			routes:  []config.IndexRoute{{Field: "country", Index: "users-v{value}"}},
+			wantErr: true,
+		},
+		{
+			name:    "listed value is the version",
+			routes:  []config.IndexRoute{{Field: "country", Values: []string{"pl", "12"}, Index: "users-v{value}"}},
+			wantErr: true,
+		},
+		{
+			name:     "listed values are not versions",
+			routes:   []config.IndexRoute{{Field: "country", Values: []string{"pl", "de"}, Index: "users-v{value}"}},
+			patterns: []string{"users-v*"},
+		},
+		{
+			name:    "overlaps with the version of custom alias",
+			alias:   "people",
+			routes:  []config.IndexRoute{{Header: "source", Index: "people-v1{value}"}},
+			wantErr: true,
+		},
+		{
+			name:     "month is not the version",
+			routes:   []config.IndexRoute{{Index: "users-v{month}"}},
+			patterns: []string{"users-v*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRouting(&config.Config{IndexAlias: tt.alias, IndexRoutes: tt.routes})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(r.Patterns(), tt.patterns) {
+				t.Errorf("patterns: got %v, want %v", r.Patterns(), tt.patterns)
+			}
+		})
+	}
+}
+
+func TestMatchesVersion(t *testing.T) {
+	idx := indices{read: "users"}
+
+	tests := []struct {
+		glob string
+		want bool
+	}{
+		{glob: "users-v1", want: true},
+		{glob: "users-v*", want: true},
+		{glob: "*", want: true},
+		{glob: "users*", want: true},
+		{glob: "*-v1*", want: true},
+		{glob: "users-v*0", want: true},
+		{glob: "users-v####", want: true},
+		{glob: "users-v0", want: false},
+		{glob: "users-v", want: false},
+		{glob: "users-v1-*", want: false},
+		{glob: "users-v*-x", want: false},
+		{glob: "users-v####.##", want: false},
+		{glob: "users-*-v1", want: false},
+		{glob: "users-country-*", want: false},
+		{glob: "customers-v*", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesVersion(idx, tt.glob); got != tt.want {
+			t.Errorf("matchesVersion(%q): got %v, want %v", tt.glob, got, tt.want)
+		}
+	}
+}
+
+func TestRoutingIndex(t *testing.T) {
+	produced := time.Date(2019, 10, 31, 23, 30, 0, 0, time.FixedZone("CET", -3600))
+	nickname := "John Doe"
+
+	r, err := newRouting(&config.Config{IndexRoutes: []config.IndexRoute{
+		{Header: "source", Values: []string{"CRM", "Web/Shop"}, Index: "users-source-{value}"},
+		{Field: "nickname", Index: "users-nick-{value}"},
+		{Field: "country", Values: []string{"48"}, Index: "users-pl-{month}"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		ev     event
+		want   string
+	}{
+		{
+			name: "no rule matches",
+			ev:   event{op: OpIndex, user: models.User{Country: 1}},
+		},
+		{
+			name:   "header value",
+			source: "crm",
+			ev:     event{op: OpIndex},
+			want:   "users-source-crm",
+		},
+		{
+			name:   "header value made index safe",
+			source: " Web/Shop ",
+			ev:     event{op: OpIndex},
+			want:   "users-source-web-shop",
+		},
+		{
+			name:   "header value not listed",
+			source: "batch",
+			ev:     event{op: OpIndex, user: models.User{Country: 48}},
+			want:   "users-pl-2019.11",
+		},
+		{
+			name: "field of the user",
+			ev:   event{op: OpIndex, user: models.User{Nickname: &nickname}},
+			want: "users-nick-john-doe",
+		},
+		{
+			name: "field of the update",
+			ev:   event{op: OpUpdate, partial: map[string]interface{}{"nickname": "Jane"}},
+			want: "users-nick-jane",
+		},
+		{
+			name: "null field of the update",
+			ev:   event{op: OpUpdate, partial: map[string]interface{}{"nickname": nil}},
+		},
+		{
+			name: "delete without fields",
+			ev:   event{op: OpDelete},
+		},
+		{
+			name: "month in UTC",
+			ev:   event{op: OpIndex, user: models.User{Country: 48}},
+			want: "users-pl-2019.11",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Timestamp: produced}
+			if tt.source != "" {
+				msg.Headers = []*sarama.RecordHeader{{Key: []byte("source"), Value: []byte(tt.source)}}
+			}
+
+			if index := r.Index(msg, tt.ev); index != tt.want {
+				t.Errorf("got %q, want %q", index, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "PL", want: "pl"},
+		{value: " a b ", want: "a-b"},
+		{value: `a\b/c*d?e"f<g>h|i,j#k:l`, want: "a-b-c-d-e-f-g-h-i-j-k-l"},
+		{value: "_+.name", want: "name"},
+		{value: "-name", want: "name"},
+		{value: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := indexSafe(tt.value); got != tt.want {
+			t.Errorf("indexSafe(%q): got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultOpenSearchIndex is used when OpenSearchIndex is not set in config.
+const DefaultOpenSearchIndex = "users"
+
+// newSinks creates sinks enabled in config, Elasticsearch only by default.
+// Elasticsearch sink writes to the write alias of the versioned index.
+func newSinks(cfg *config.Config, client *elastic.Client, idx indices) ([]sink.Sink, error) {
+	names := cfg.Sinks
+	if len(names) == 0 {
+		names = []string{sink.Elasticsearch}
+	}
+
+	var sinks []sink.Sink
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			closeSinks(sinks)
+			return nil, fmt.Errorf("sink %q configured twice", name)
+		}
+		seen[name] = true
+
+		var (
+			s   sink.Sink
+			err error
+		)
+		switch name {
+		case sink.Elasticsearch:
+			s = sink.NewElasticsearch(client, idx.write)
+		case sink.OpenSearch:
+			s, err = newOpenSearchSink(cfg)
+		case sink.File:
+			if cfg.ArchiveDir == "" {
+				err = errors.New("ArchiveDir is required by file sink")
+				break
+			}
+			s, err = sink.NewFile(cfg.ArchiveDir, cfg.ArchiveMaxBytes, cfg.ArchiveGzip)
+		case sink.Bleve:
+			if cfg.BlevePath == "" {
+				err = errors.New("BlevePath is required by bleve sink")
+				break
+			}
+			s, err = sink.NewBleve(cfg.BlevePath)
+		default:
+			err = fmt.Errorf("unknown sink %q", name)
+		}
+		if err != nil {
+			closeSinks(sinks)
+			return nil, fmt.Errorf("can't create %s sink: %w", name, err)
+		}
+
+		sinks = append(sinks, s)
+	}
+
+	return sinks, nil
+}
+
+// newOpenSearchSink connects to the OpenSearch cluster and creates the index when it doesn't exist.
+func newOpenSearchSink(cfg *config.Config) (sink.Sink, error) {
+	if len(cfg.OpenSearch.URLs) == 0 {
+		return nil, errors.New("OpenSearch.URLs are required by opensearch sink")
+	}
+
+	index := cfg.OpenSearchIndex
+	if index == "" {
+		index = DefaultOpenSearchIndex
+	}
+
+	client, err := esclient.New(cfg.OpenSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err == nil && !exists {
+		err = createIndex(ctx, client, index)
+	}
+	if err != nil {
+		client.Stop()
+		return nil, fmt.Errorf("can't prepare index %s: %w", index, err)
+	}
+
+	return sink.NewOpenSearch(client, index), nil
+}
+
+// closeSinks flushes and closes sinks.
+func closeSinks(sinks []sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Flush(context.Background()); err != nil {
+			log.Errorf("Error flushing %s sink: %v", s.Name(), err)
+		}
+		if err := s.Close(); err != nil {
+			log.Errorf("Error closing %s sink: %v", s.Name(), err)
+		}
+	}
+}
+
+// sinkLabel returns the value of the index label of metrics - the index written by the sink or its name.
+func sinkLabel(s sink.Sink) string {
+	if indexed, ok := s.(interface{ Index() string }); ok {
+		return indexed.Index()
+	}
+
+	return s.Name()
+}
+
+// hasSink says if the sink with given name is enabled.
+func hasSink(sinks []sink.Sink, name string) bool {
+	for _, s := range sinks {
+		if s.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,321 @@
+package indexer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/sink"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ChecksumSuffix is appended to the name of the snapshot to get the file with its SHA-256 checksum.
+	// Checksum file has the format of sha256sum, so the snapshot can be verified with 'sha256sum -c'.
+	ChecksumSuffix = ".sha256"
+
+	// snapshotBatchSize is the number of documents fetched by a single scroll request.
+	snapshotBatchSize = 1000
+	// snapshotProgressInterval is the time between progress logs of snapshot and restore.
+	snapshotProgressInterval = 10 * time.Second
+)
+
+// snapshotDoc is the line of the snapshot.
+type snapshotDoc struct {
+	Index   string          `json:"index"`
+	ID      string          `json:"id"`
+	Version int64           `json:"version,omitempty"`
+	Source  json.RawMessage `json:"source"`
+}
+
+// Snapshot exports documents of the index (read alias by default) into the gzipped NDJSON file and writes
+// its SHA-256 checksum next to it. File is named after the index and the time when it's not set.
+// Documents are read with the scroll, so the snapshot is consistent as of its start.
+func Snapshot(cfg *config.Config, index, file string) (int, error) {
+	ctx := context.Background()
+	if index == "" {
+		index = newIndices(cfg).read
+	}
+	if file == "" {
+		file = fmt.Sprintf("%s-%s.ndjson.gz", index, time.Now().UTC().Format("20060102T150405"))
+	}
+
+	client, err := esclient.New(cfg.Elastic)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Stop()
+
+	// snapshot is written to the temporary file, so the interrupted one is never taken for complete
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("can't create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hash))
+	w := bufio.NewWriter(gz)
+	enc := json.NewEncoder(w)
+
+	scroll := client.Scroll(index).
+		Size(snapshotBatchSize).
+		KeepAlive("5m").
+		Sort("_doc", true).
+		Version(true).
+		TrackTotalHits(true)
+	defer scroll.Clear(context.Background())
+
+	log.Infof("Taking snapshot of '%s' into '%s'", index, file)
+	var written int
+	progress := time.Now()
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, fmt.Errorf("can't read documents of %s: %w", index, err)
+		}
+
+		for _, hit := range res.Hits.Hits {
+			doc := snapshotDoc{Index: hit.Index, ID: hit.Id, Source: hit.Source}
+			if hit.Version != nil {
+				doc.Version = *hit.Version
+			}
+
+			if err := enc.Encode(doc); err != nil {
+				return written, fmt.Errorf("can't write snapshot: %w", err)
+			}
+			written++
+		}
+
+		if time.Since(progress) >= snapshotProgressInterval {
+			log.Infof("Snapshot of '%s': %d/%d documents", index, written, res.TotalHits())
+			progress = time.Now()
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return written, fmt.Errorf("can't write snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return written, fmt.Errorf("can't write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return written, fmt.Errorf("can't write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return written, fmt.Errorf("can't write snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return written, fmt.Errorf("can't write snapshot: %w", err)
+	}
+
+	// checksum is written once the snapshot is in place, so it never describes the file which doesn't exist
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err := writeChecksum(file, sum); err != nil {
+		return written, err
+	}
+
+	log.Infof("Snapshot of %d documents of '%s' written to '%s'. SHA-256: %s", written, index, file, sum)
+
+	return written, nil
+}
+
+// Restore verifies the checksum of the snapshot and indexes its documents into the new index,
+// the physical index of configured version by default. Documents of indices created by routing rules
+// are restored into their indices. When swap is set, read and write aliases are pointed to the index.
+func Restore(cfg *config.Config, file, index string, swap bool) (int, error) {
+	ctx := context.Background()
+	idx := newIndices(cfg)
+	if index == "" {
+		index = idx.Physical(idx.version)
+	}
+
+	routing, err := newRouting(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := verifyChecksum(file); err != nil {
+		return 0, err
+	}
+
+	client, err := esclient.New(cfg.Elastic)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Stop()
+
+	exists, err := client.IndexExists(index).Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("can't check if index %s exists: %w", index, err)
+	}
+	if exists {
+		return 0, fmt.Errorf("index %s already exists", index)
+	}
+
+	if err := createIndex(ctx, client, index); err != nil {
+		return 0, err
+	}
+	if len(routing.routes) > 0 {
+		if err := ensureRoutedIndices(ctx, client, idx, routing); err != nil {
+			return 0, err
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, fmt.Errorf("can't open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("can't open snapshot: %w", err)
+	}
+
+	read := &countingReader{r: f}
+	gz, err := gzip.NewReader(bufio.NewReader(read))
+	if err != nil {
+		return 0, fmt.Errorf("can't read snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	l := &loader{w: newBulkWriter(cfg), s: sink.NewElasticsearch(client, index)}
+	log.Infof("Restoring '%s' into '%s'", file, index)
+	dec := json.NewDecoder(gz)
+	progress := time.Now()
+	for {
+		var doc snapshotDoc
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return l.written, fmt.Errorf("can't read snapshot: %w", err)
+		}
+
+		ev := event{op: OpIndex, id: doc.ID, version: doc.Version}
+		if routed(routing, doc.Index) {
+			ev.index = doc.Index
+		}
+		if err := json.Unmarshal(doc.Source, &ev.user); err != nil {
+			log.Errorf("can't restore user %s. Err: %v", doc.ID, err)
+			l.rejected++
+			continue
+		}
+
+		if err := l.Add(ctx, &document{ev: ev, msg: &sarama.ConsumerMessage{Key: []byte(doc.ID), Value: doc.Source}}); err != nil {
+			return l.written, err
+		}
+
+		if time.Since(progress) >= snapshotProgressInterval {
+			log.Infof("Restoring '%s': %d documents, %d%% of the snapshot", index, l.written, 100*read.n/(info.Size()+1))
+			progress = time.Now()
+		}
+	}
+	if err := l.Flush(ctx); err != nil {
+		return l.written, err
+	}
+
+	n := l.written
+	if l.rejected > 0 {
+		return n, fmt.Errorf("%d documents rejected by %s", l.rejected, index)
+	}
+	log.Infof("Restored %d documents into '%s'", n, index)
+
+	if swap {
+		return n, swapAliases(ctx, client, index, idx.read, idx.write)
+	}
+
+	return n, nil
+}
+
+// writeChecksum writes the checksum file of the snapshot in the format of sha256sum. It's written to the temporary
+// file first, so the checksum file is either complete or missing.
+func writeChecksum(file, sum string) error {
+	tmp := file + ChecksumSuffix + ".tmp"
+	checksum := fmt.Sprintf("%s  %s\n", sum, filepath.Base(file))
+	if err := ioutil.WriteFile(tmp, []byte(checksum), 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("can't write checksum: %w", err)
+	}
+	if err := os.Rename(tmp, file+ChecksumSuffix); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("can't write checksum: %w", err)
+	}
+
+	return nil
+}
+
+// verifyChecksum compares SHA-256 of the snapshot with the one from its checksum file.
+func verifyChecksum(file string) error {
+	data, err := ioutil.ReadFile(file + ChecksumSuffix)
+	if err != nil {
+		return fmt.Errorf("can't read checksum: %w", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return errors.New("checksum file is empty")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("can't open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("can't read snapshot: %w", err)
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, fields[0]) {
+		return fmt.Errorf("checksum of %s doesn't match: got %s, expected %s", file, sum, fields[0])
+	}
+
+	return nil
+}
+
+// routed says if the index was created by routing rules.
+func routed(r routing, index string) bool {
+	for _, pattern := range r.Patterns() {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// countingReader counts bytes read, so the progress of the restore can be reported.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,174 @@
+package indexer
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+)
+
+// fakeElastic serves the scroll over given hits and records bulk actions.
+type fakeElastic struct {
+	hits string
+
+	mu      sync.Mutex
+	actions []string
+	created []string
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/users/_search":
+		fmt.Fprintf(w, `{"_scroll_id": "s1", "hits": {"total": {"value": 2, "relation": "eq"}, "hits": %s}}`, f.hits)
+	case r.URL.Path == "/_search/scroll" && r.Method == http.MethodDelete:
+		fmt.Fprint(w, `{"succeeded": true, "num_freed": 1}`)
+	case r.URL.Path == "/_search/scroll":
+		fmt.Fprint(w, `{"_scroll_id": "s1", "hits": {"total": {"value": 2, "relation": "eq"}, "hits": []}}`)
+	case r.URL.Path == "/_bulk":
+		f.bulk(w, r)
+	case r.Method == http.MethodHead && r.URL.Path != "/":
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		f.mu.Lock()
+		f.created = append(f.created, strings.TrimPrefix(r.URL.Path, "/"))
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"acknowledged": true}`)
+	default:
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// bulk records actions with their documents and acknowledges all of them.
+func (f *fakeElastic) bulk(w http.ResponseWriter, r *http.Request) {
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := scanner.Text()
+		if !scanner.Scan() {
+			break
+		}
+
+		f.mu.Lock()
+		f.actions = append(f.actions, action+" "+scanner.Text())
+		f.mu.Unlock()
+		items = append(items, `{"index": {"status": 201}}`)
+	}
+
+	fmt.Fprintf(w, `{"errors": false, "items": [%s]}`, strings.Join(items, ","))
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	es := &fakeElastic{hits: `[
+		{"_index": "users-v1", "_id": "1", "_version": 5, "_source": {"id": 1}},
+		{"_index": "users-v1", "_id": "2", "_source": {"id": 2}}
+	]`}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{Elastic: esclient.Config{URLs: []string{srv.URL}}}
+	file := filepath.Join(dir, "users.ndjson.gz")
+
+	written, err := Snapshot(cfg, "", file)
+	if err != nil {
+		t.Fatalf("can't take snapshot: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("got %d documents written, want 2", written)
+	}
+
+	checksum, err := ioutil.ReadFile(file + ChecksumSuffix)
+	if err != nil {
+		t.Fatalf("can't read checksum: %v", err)
+	}
+	if !strings.HasSuffix(string(checksum), "  users.ndjson.gz\n") {
+		t.Errorf("checksum is not in sha256sum format: %q", checksum)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+
+	restored, err := Restore(cfg, file, "users-v9", false)
+	if err != nil {
+		t.Fatalf("can't restore snapshot: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("got %d documents restored, want 2", restored)
+	}
+
+	if want := []string{"users-v9"}; !reflect.DeepEqual(es.created, want) {
+		t.Errorf("created indices: got %v, want %v", es.created, want)
+	}
+	want := []string{
+		`{"index":{"_index":"users-v9","_id":"1","_type":"_doc","version":5,"version_type":"external_gte"}} {"id":1}`,
+		`{"index":{"_index":"users-v9","_id":"2","_type":"_doc"}} {"id":2}`,
+	}
+	if !reflect.DeepEqual(es.actions, want) {
+		t.Errorf("bulk actions:\ngot  %q\nwant %q", es.actions, want)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	const (
+		content = "snapshot"
+		// sha256 of the content
+		sum = "16a0eeb0791b6c92451fd284dd9f599e0a7dbe7f6ebea6e2d2d06c7f74aec112"
+	)
+
+	tests := []struct {
+		name     string
+		checksum string
+		wantErr  string
+	}{
+		{name: "valid", checksum: sum + "  users.ndjson.gz\n"},
+		{name: "upper case", checksum: strings.ToUpper(sum) + "  users.ndjson.gz\n"},
+		{name: "only sum", checksum: sum},
+		{name: "mismatch", checksum: strings.Repeat("0", 64) + "  users.ndjson.gz\n", wantErr: "doesn't match"},
+		{name: "empty", checksum: "\n", wantErr: "checksum file is empty"},
+		{name: "missing", wantErr: "can't read checksum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "checksum")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			file := filepath.Join(dir, "users.ndjson.gz")
+			if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.checksum != "" {
+				if err := ioutil.WriteFile(file+ChecksumSuffix, []byte(tt.checksum), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = verifyChecksum(file)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
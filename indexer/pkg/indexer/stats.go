@@ -0,0 +1,275 @@
+package indexer
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultLagRefreshInterval is the time after which high watermarks of claimed partitions are fetched from Kafka,
+// so lag grows while consumption stalls or is paused.
+const DefaultLagRefreshInterval = 15 * time.Second
+
+// Status summarises the consumer group membership and progress of the indexer.
+type Status struct {
+	Group         string            `json:"group"`
+	MemberID      string            `json:"memberId,omitempty"`
+	Generation    int32             `json:"generation"`
+	Paused        bool              `json:"paused"`
+	Rebalances    int               `json:"rebalances"`
+	LastRebalance *time.Time        `json:"lastRebalance,omitempty"`
+	Lag           int64             `json:"lag"`
+	Partitions    []PartitionStatus `json:"partitions"`
+	Replay        ReplayStatus      `json:"replay"`
+}
+
+// PartitionStatus describes progress of the claimed partition.
+type PartitionStatus struct {
+	Topic         string     `json:"topic"`
+	Partition     int32      `json:"partition"`
+	HighWatermark int64      `json:"highWatermark"`
+	Processed     int64      `json:"processed"`
+	Lag           int64      `json:"lag"`
+	LastMessage   *time.Time `json:"lastMessage,omitempty"`
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// stats keeps the partition assignment and lag of the consumer and exports them as metrics.
+// Lag is the difference between the high watermark and the offset of the next message to process.
+// High watermarks come with messages and are refreshed every DefaultLagRefreshInterval.
+type stats struct {
+	mu            sync.Mutex
+	group         string
+	memberID      string
+	generation    int32
+	rebalances    int
+	lastRebalance time.Time
+	partitions    map[topicPartition]*PartitionStatus
+
+	lag             *prometheus.GaugeVec
+	assigned        *prometheus.GaugeVec
+	rebalancesTotal prometheus.Counter
+	latency         *prometheus.HistogramVec
+}
+
+func newStats(group string) *stats {
+	lag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "consumer_lag",
+			Help:      "The number of messages in the partition which are not processed yet.",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	assigned := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "partition_assigned",
+			Help:      "Partitions claimed by the indexer in the current consumer group session.",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	rebalances := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "rebalances_total",
+			Help:      "The total number of consumer group sessions started by the indexer.",
+		},
+	)
+
+	latency := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "end_to_end_latency_seconds",
+			Help:      "Seconds between producing the message to Kafka and acknowledging it by Elasticsearch.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		},
+		[]string{"topic"},
+	)
+
+	prometheus.Register(lag)
+	prometheus.Register(assigned)
+	prometheus.Register(rebalances)
+	prometheus.Register(latency)
+
+	return &stats{
+		group:           group,
+		partitions:      make(map[topicPartition]*PartitionStatus),
+		lag:             lag,
+		assigned:        assigned,
+		rebalancesTotal: rebalances,
+		latency:         latency,
+	}
+}
+
+// Assign replaces the assignment with partitions claimed by the new session.
+func (s *stats) Assign(session sarama.ConsumerGroupSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memberID = session.MemberID()
+	s.generation = session.GenerationID()
+	s.rebalances++
+	s.lastRebalance = time.Now()
+	s.rebalancesTotal.Inc()
+
+	s.lag.Reset()
+	s.assigned.Reset()
+	s.partitions = make(map[topicPartition]*PartitionStatus)
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			s.partitions[topicPartition{topic, partition}] = &PartitionStatus{Topic: topic, Partition: partition, Processed: -1}
+			s.assigned.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(1)
+		}
+	}
+}
+
+// Revoke clears the assignment when the session ends.
+func (s *stats) Revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lag.Reset()
+	s.assigned.Reset()
+	s.partitions = make(map[topicPartition]*PartitionStatus)
+}
+
+// Claimed sets the offset of the next message to process when the claim starts, so lag is known before
+// the first message is received. Negative offsets (oldest or newest) are resolved when messages arrive.
+func (s *stats) Claimed(topic string, partition int32, initial int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.partitions[topicPartition{topic, partition}]
+	if !ok || initial < 0 {
+		return
+	}
+
+	p.Processed = initial
+}
+
+// Refresh fetches high watermarks of claimed partitions from Kafka and updates their lag.
+func (s *stats) Refresh(client sarama.Client) {
+	s.mu.Lock()
+	claimed := make([]topicPartition, 0, len(s.partitions))
+	for tp := range s.partitions {
+		claimed = append(claimed, tp)
+	}
+	s.mu.Unlock()
+
+	for _, tp := range claimed {
+		highWatermark, err := client.GetOffset(tp.topic, tp.partition, sarama.OffsetNewest)
+		if err != nil {
+			log.Warnf("can't get high watermark of %s/%d. Err: %v", tp.topic, tp.partition, err)
+			continue
+		}
+
+		s.mu.Lock()
+		// partition could be revoked in the meantime
+		if p, ok := s.partitions[tp]; ok && highWatermark > p.HighWatermark {
+			p.HighWatermark = highWatermark
+			if p.Processed >= 0 {
+				s.updateLag(p)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Received updates the high watermark of the partition.
+func (s *stats) Received(msg *sarama.ConsumerMessage, highWatermark int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.partitions[topicPartition{msg.Topic, msg.Partition}]
+	if !ok {
+		return
+	}
+
+	p.HighWatermark = highWatermark
+	if p.Processed < 0 {
+		p.Processed = msg.Offset
+	}
+	if !msg.Timestamp.IsZero() {
+		ts := msg.Timestamp
+		p.LastMessage = &ts
+	}
+	s.updateLag(p)
+}
+
+// Processed moves the offset of the next message to process.
+func (s *stats) Processed(topic string, partition int32, next int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.partitions[topicPartition{topic, partition}]
+	if !ok {
+		return
+	}
+
+	p.Processed = next
+	s.updateLag(p)
+}
+
+// Acknowledged observes the time since the message was produced.
+func (s *stats) Acknowledged(msg *sarama.ConsumerMessage) {
+	if msg.Timestamp.IsZero() {
+		return
+	}
+
+	s.latency.WithLabelValues(msg.Topic).Observe(time.Since(msg.Timestamp).Seconds())
+}
+
+func (s *stats) updateLag(p *PartitionStatus) {
+	p.Lag = p.HighWatermark - p.Processed
+	if p.Lag < 0 {
+		p.Lag = 0
+	}
+	s.lag.WithLabelValues(p.Topic, strconv.Itoa(int(p.Partition))).Set(float64(p.Lag))
+}
+
+// Status returns the summary of the consumer group membership and partitions.
+func (s *stats) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Group:      s.group,
+		MemberID:   s.memberID,
+		Generation: s.generation,
+		Rebalances: s.rebalances,
+		Partitions: make([]PartitionStatus, 0, len(s.partitions)),
+	}
+	if !s.lastRebalance.IsZero() {
+		last := s.lastRebalance
+		status.LastRebalance = &last
+	}
+
+	for _, p := range s.partitions {
+		status.Partitions = append(status.Partitions, *p)
+		status.Lag += p.Lag
+	}
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		if status.Partitions[i].Topic != status.Partitions[j].Topic {
+			return status.Partitions[i].Topic < status.Partitions[j].Topic
+		}
+		return status.Partitions[i].Partition < status.Partitions[j].Partition
+	})
+
+	return status
+}
@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+// DefaultTopicRefreshInterval is the time after which topics matching the pattern are looked up again.
+const DefaultTopicRefreshInterval = time.Minute
+
+// subscription resolves topics consumed by the indexer: the configured ones and the ones matching the pattern.
+type subscription struct {
+	topics  []string
+	pattern *regexp.Regexp
+	exclude map[string]bool
+
+	mu      sync.Mutex
+	current []string
+}
+
+func newSubscription(cfg *config.Config) (*subscription, error) {
+	dlq := cfg.DLQTopic
+	if dlq == "" {
+		dlq = DefaultDLQTopic
+	}
+	s := &subscription{exclude: map[string]bool{dlq: true}}
+
+	for _, topic := range append([]string{cfg.Topic}, cfg.Topics...) {
+		if topic != "" && !contains(s.topics, topic) {
+			s.topics = append(s.topics, topic)
+		}
+	}
+
+	if cfg.TopicPattern != "" {
+		pattern, err := regexp.Compile(cfg.TopicPattern)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse topic pattern: %w", err)
+		}
+		s.pattern = pattern
+	}
+
+	if len(s.topics) == 0 && s.pattern == nil {
+		return nil, errors.New("no topics to consume: set Topic, Topics or TopicPattern")
+	}
+
+	return s, nil
+}
+
+// Resolve returns topics which should be consumed. Internal and dead-letter topics never match the pattern.
+func (s *subscription) Resolve(client sarama.Client) ([]string, error) {
+	topics := append([]string(nil), s.topics...)
+
+	if s.pattern != nil {
+		if err := client.RefreshMetadata(); err != nil {
+			return nil, fmt.Errorf("can't refresh metadata: %w", err)
+		}
+
+		all, err := client.Topics()
+		if err != nil {
+			return nil, fmt.Errorf("can't list topics: %w", err)
+		}
+
+		for _, topic := range all {
+			if strings.HasPrefix(topic, "__") || s.exclude[topic] || contains(topics, topic) {
+				continue
+			}
+			if s.pattern.MatchString(topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sort.Strings(topics)
+
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no topics match %q", s.pattern)
+	}
+
+	s.mu.Lock()
+	s.current = topics
+	s.mu.Unlock()
+
+	return topics, nil
+}
+
+// Current returns topics resolved most recently.
+func (s *subscription) Current() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current
+}
+
+// Changed says if topics matching the pattern are different than the consumed ones.
+func (s *subscription) Changed(client sarama.Client) bool {
+	if s.pattern == nil {
+		return false
+	}
+
+	current := s.Current()
+	topics, err := s.Resolve(client)
+	if err != nil {
+		return false
+	}
+
+	return strings.Join(current, ",") != strings.Join(topics, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
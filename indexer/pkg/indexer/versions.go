@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+// Sources of the external version of the user document.
+const (
+	// VersionNone disables external versioning, the last write wins.
+	VersionNone = "none"
+	// VersionTimestamp takes the version from the timestamp of Kafka message.
+	VersionTimestamp = "timestamp"
+	// VersionOffset takes the version from the offset of Kafka message. It's valid only when all
+	// messages of the user go to the same partition, e.g. are keyed with the user id.
+	VersionOffset = "offset"
+	// VersionField takes the version from the numeric field of the message.
+	VersionField = "field"
+)
+
+const (
+	// DefaultVersionSource is used when VersionSource is not set in config.
+	DefaultVersionSource = VersionTimestamp
+	// DefaultVersionField is used when VersionField is not set in config.
+	DefaultVersionField = "version"
+)
+
+// versioning resolves the external version of the user from Kafka message. Documents are written with
+// external_gte version type, so the older version of the user never overwrites the newer one.
+type versioning struct {
+	source string
+	field  string
+}
+
+func newVersioning(cfg *config.Config) (versioning, error) {
+	v := versioning{source: cfg.VersionSource, field: cfg.VersionField}
+	if v.source == "" {
+		v.source = DefaultVersionSource
+	}
+	if v.field == "" {
+		v.field = DefaultVersionField
+	}
+
+	switch v.source {
+	case VersionNone, VersionTimestamp, VersionOffset, VersionField:
+		return v, nil
+	default:
+		return v, fmt.Errorf("unknown version source %q", v.source)
+	}
+}
+
+// version returns the version of the user or 0 when the document should not be versioned.
+// Tombstones have no fields, so they are not versioned when the version is taken from the field.
+func (v versioning) version(msg *sarama.ConsumerMessage, fields map[string]interface{}) (int64, error) {
+	switch v.source {
+	case VersionTimestamp:
+		if msg.Timestamp.IsZero() {
+			return 0, fmt.Errorf("message without timestamp")
+		}
+		return msg.Timestamp.UnixNano() / 1e6, nil
+	case VersionOffset:
+		return msg.Offset + 1, nil
+	case VersionField:
+		if fields == nil {
+			return 0, nil
+		}
+		n, ok := fields[v.field].(json.Number)
+		if !ok {
+			return 0, fmt.Errorf("missing numeric %s field", v.field)
+		}
+		version, err := n.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s field: %w", v.field, err)
+		}
+		return version, nil
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,130 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+func TestNewVersioning(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{source: "", want: VersionTimestamp},
+		{source: VersionNone, want: VersionNone},
+		{source: VersionOffset, want: VersionOffset},
+		{source: VersionField, want: VersionField},
+		{source: "random", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			v, err := newVersioning(&config.Config{VersionSource: tt.source})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && (v.source != tt.want || v.field != DefaultVersionField) {
+				t.Errorf("got %+v, want source %s and field %s", v, tt.want, DefaultVersionField)
+			}
+		})
+	}
+}
+
+func TestDecodeEventVersion(t *testing.T) {
+	timestamp := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		source    string
+		value     []byte
+		timestamp time.Time
+		want      int64
+		wantErr   bool
+	}{
+		{
+			name:      "timestamp",
+			source:    VersionTimestamp,
+			value:     []byte(`{"id": 1}`),
+			timestamp: timestamp,
+			want:      timestamp.UnixNano() / 1e6,
+		},
+		{
+			name:      "tombstone with timestamp",
+			source:    VersionTimestamp,
+			timestamp: timestamp,
+			want:      timestamp.UnixNano() / 1e6,
+		},
+		{
+			name:    "missing timestamp",
+			source:  VersionTimestamp,
+			value:   []byte(`{"id": 1}`),
+			wantErr: true,
+		},
+		{
+			name:   "offset",
+			source: VersionOffset,
+			value:  []byte(`{"id": 1}`),
+			want:   43,
+		},
+		{
+			name:   "field",
+			source: VersionField,
+			value:  []byte(`{"id": 1, "version": 1569931200000}`),
+			want:   1569931200000,
+		},
+		{
+			name:   "field of update",
+			source: VersionField,
+			value:  []byte(`{"op": "update", "id": 1, "version": 5}`),
+			want:   5,
+		},
+		{
+			name:    "missing field",
+			source:  VersionField,
+			value:   []byte(`{"id": 1}`),
+			wantErr: true,
+		},
+		{
+			name:    "fractional field",
+			source:  VersionField,
+			value:   []byte(`{"id": 1, "version": 1.5}`),
+			wantErr: true,
+		},
+		{
+			name:    "string field",
+			source:  VersionField,
+			value:   []byte(`{"id": 1, "version": "5"}`),
+			wantErr: true,
+		},
+		{
+			name:   "tombstone with field",
+			source: VersionField,
+			want:   0,
+		},
+		{
+			name:      "none",
+			source:    VersionNone,
+			value:     []byte(`{"id": 1}`),
+			timestamp: timestamp,
+			want:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Key: []byte("1"), Value: tt.value, Offset: 42, Timestamp: tt.timestamp}
+
+			ev, err := decodeEvent(msg, versioning{source: tt.source, field: DefaultVersionField})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && ev.version != tt.want {
+				t.Errorf("got version %d, want %d", ev.version, tt.want)
+			}
+		})
+	}
+}
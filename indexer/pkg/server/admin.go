@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
+)
+
+// Admin controls consumption and offsets of the indexer.
+type Admin interface {
+	Pause()
+	Resume()
+	Paused() bool
+	ResetOffsets(ctx context.Context, topic string, offsets map[int32]int64) (map[int32]int64, error)
+	ResetOffsetsToTime(ctx context.Context, topic string, at time.Time) (map[int32]int64, error)
+	Replay(ctx context.Context, topic, index string, from, to time.Time) error
+	ReplayStatus() indexer.ReplayStatus
+}
+
+// WithAdmin registers admin endpoints. Requests have to be authorized with the bearer token.
+func WithAdmin(admin Admin, token string) func(*Server) {
+	return func(s *Server) {
+		r := s.mux.PathPrefix("/admin").Subrouter()
+		r.Use(authorize(token))
+
+		r.HandleFunc("/pause", s.pause(admin)).Methods(http.MethodPost)
+		r.HandleFunc("/resume", s.resume(admin)).Methods(http.MethodPost)
+		r.HandleFunc("/offsets", s.resetOffsets(admin)).Methods(http.MethodPost)
+		r.HandleFunc("/replay", s.replay(admin)).Methods(http.MethodPost)
+		r.HandleFunc("/replay", s.replayStatus(admin)).Methods(http.MethodGet)
+	}
+}
+
+// offsetsRequest moves offsets to given ones or to the given time.
+type offsetsRequest struct {
+	Topic     string          `json:"topic"`
+	Offsets   map[int32]int64 `json:"offsets"`
+	Timestamp *time.Time      `json:"timestamp"`
+}
+
+// replayRequest indexes messages produced between 'from' and 'to' into the index.
+type replayRequest struct {
+	Topic string    `json:"topic"`
+	Index string    `json:"index"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+}
+
+func (s *Server) pause(admin Admin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin.Pause()
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": admin.Paused()})
+	}
+}
+
+func (s *Server) resume(admin Admin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin.Resume()
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": admin.Paused()})
+	}
+}
+
+func (s *Server) resetOffsets(admin Admin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req offsetsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if (req.Timestamp == nil) == (len(req.Offsets) == 0) {
+			writeError(w, http.StatusBadRequest, errors.New("either 'offsets' or 'timestamp' is required"))
+			return
+		}
+
+		var (
+			applied map[int32]int64
+			err     error
+		)
+		if req.Timestamp != nil {
+			applied, err = admin.ResetOffsetsToTime(r.Context(), req.Topic, *req.Timestamp)
+		} else {
+			applied, err = admin.ResetOffsets(r.Context(), req.Topic, req.Offsets)
+		}
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"applied": applied})
+	}
+}
+
+func (s *Server) replay(admin Admin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := admin.Replay(r.Context(), req.Topic, req.Index, req.From, req.To); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, admin.ReplayStatus())
+	}
+}
+
+func (s *Server) replayStatus(admin Admin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, admin.ReplayStatus())
+	}
+}
+
+// authorize rejects requests without the bearer token.
+func authorize(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			given := strings.TrimPrefix(header, "Bearer ")
+			if given == header || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, indexer.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, indexer.ErrReplayRunning):
+		return http.StatusConflict
+	case errors.Is(err, indexer.ErrNotIndexing):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(d)
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{name: "valid token", header: "Bearer secret", code: http.StatusOK},
+		{name: "missing header", code: http.StatusUnauthorized},
+		{name: "token without bearer prefix", header: "secret", code: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer other", code: http.StatusUnauthorized},
+		{name: "other scheme", header: "Basic secret", code: http.StatusUnauthorized},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			authorize("secret")(ok).ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Errorf("code: got %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"sync/atomic"
 
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/version"
 )
 
@@ -30,6 +31,31 @@ func (s *Server) version(w http.ResponseWriter, r *http.Request) {
 	w.Write(d)
 }
 
+// StatusProvider returns the summary of the indexer progress.
+type StatusProvider interface {
+	Status() indexer.Status
+}
+
+// WithStatus registers the /status endpoint.
+func WithStatus(status StatusProvider) func(*Server) {
+	return func(s *Server) {
+		s.status = status
+	}
+}
+
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := json.Marshal(s.status.Status())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	if atomic.LoadInt32(&healthy) == 1 {
 		w.WriteHeader(http.StatusOK)
@@ -40,10 +66,10 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
-	if atomic.LoadInt32(&ready) == 1 {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+	if atomic.LoadInt32(&ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	w.WriteHeader(http.StatusServiceUnavailable)
+
+	s.checks.ServeHTTP(w, r)
 }
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -21,11 +22,13 @@ var (
 )
 
 type Server struct {
-	mux *mux.Router
+	mux    *mux.Router
+	checks *checks.Checker
+	status StatusProvider
 }
 
-func NewServer(cfg *config.Config, options ...func(*Server)) *Server {
-	s := &Server{mux: mux.NewRouter()}
+func NewServer(cfg *config.Config, checker *checks.Checker, options ...func(*Server)) *Server {
+	s := &Server{mux: mux.NewRouter(), checks: checker}
 
 	for _, f := range options {
 		f(s)
@@ -35,6 +38,9 @@ func NewServer(cfg *config.Config, options ...func(*Server)) *Server {
 	s.mux.HandleFunc("/health", s.health)
 	s.mux.HandleFunc("/ready", s.ready)
 	s.mux.HandleFunc("/version", s.version)
+	if s.status != nil {
+		s.mux.HandleFunc("/status", s.statusHandler)
+	}
 
 	// metrics
 	s.mux.Handle("/metrics", promhttp.Handler())
@@ -48,11 +54,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func ListenAndServe(cfg *config.Config, cancelCtx context.Context) {
+func ListenAndServe(cfg *config.Config, checker *checks.Checker, cancelCtx context.Context, options ...func(*Server)) {
 	inst := NewInstrument()
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.HTTPPort),
-		Handler:      inst.Wrap(NewServer(cfg)),
+		Handler:      inst.Wrap(NewServer(cfg, checker, options...)),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 1 * time.Minute,
 		IdleTimeout:  15 * time.Second,
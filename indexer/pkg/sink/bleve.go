@@ -0,0 +1,205 @@
+package sink
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/blevesearch/bleve"
+	// keyword analyzer is used by the mapping
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// Keys of the internal storage of the Bleve index. Source of the user is kept for partial updates
+// and its version for skipping outdated operations.
+const (
+	bleveSourcePrefix  = "src:"
+	bleveVersionPrefix = "ver:"
+)
+
+// BleveSink writes users to the embedded Bleve index.
+type BleveSink struct {
+	index bleve.Index
+}
+
+// NewBleve opens the Bleve index at given path or creates it when it doesn't exist.
+func NewBleve(path string) (*BleveSink, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(path, bleveMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't open bleve index %s: %w", path, err)
+	}
+
+	return &BleveSink{index: index}, nil
+}
+
+// bleveMapping maps the location of the user as geo point and derived fields as keywords.
+func bleveMapping() mapping.IndexMapping {
+	user := bleve.NewDocumentMapping()
+	user.AddFieldMappingsAt("location", bleve.NewGeoPointFieldMapping())
+
+	keyword := bleve.NewTextFieldMapping()
+	keyword.Analyzer = "keyword"
+	for _, field := range []string{"age_bucket", "country_name", "country_iso", "geohash", "email_domain", "geo_city", "geo_region", "geo_country"} {
+		user.AddFieldMappingsAt(field, keyword)
+	}
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = user
+
+	return m
+}
+
+// Name returns name of the sink.
+func (s *BleveSink) Name() string {
+	return Bleve
+}
+
+// Write indexes or partially updates users in a single batch.
+func (s *BleveSink) Write(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.apply(ops)
+}
+
+// Delete removes users in a single batch.
+func (s *BleveSink) Delete(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.apply(ops)
+}
+
+// Flush does nothing - batches are persisted once they are applied.
+func (s *BleveSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Health checks if the index can be read.
+func (s *BleveSink) Health(ctx context.Context) error {
+	if _, err := s.index.DocCount(); err != nil {
+		return fmt.Errorf("can't read bleve index: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the index.
+func (s *BleveSink) Close() error {
+	return s.index.Close()
+}
+
+// apply writes operations in a single batch. Operations older than the stored version are skipped.
+func (s *BleveSink) apply(ops []Operation) ([]error, error) {
+	errs := make([]error, len(ops))
+	batch := s.index.NewBatch()
+
+	// state of the users changed by the batch which is not stored yet
+	sources := make(map[string][]byte)
+	versions := make(map[string]int64)
+
+	for i, op := range ops {
+		version, err := s.version(op.ID, versions)
+		if err != nil {
+			return nil, Temporary(err)
+		}
+		if op.Version > 0 && version > op.Version {
+			errs[i] = ErrConflict
+			continue
+		}
+
+		switch op.Op {
+		case OpDelete:
+			batch.Delete(op.ID)
+			batch.DeleteInternal([]byte(bleveSourcePrefix + op.ID))
+			sources[op.ID] = nil
+		case OpUpdate:
+			user, source, err := s.merge(op, sources)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			if err := batch.Index(op.ID, user); err != nil {
+				errs[i] = fmt.Errorf("can't index user %s: %w", op.ID, err)
+				continue
+			}
+			batch.SetInternal([]byte(bleveSourcePrefix+op.ID), source)
+			sources[op.ID] = source
+		default:
+			source, err := json.Marshal(op.User)
+			if err != nil {
+				errs[i] = fmt.Errorf("can't encode user %s: %w", op.ID, err)
+				continue
+			}
+			if err := batch.Index(op.ID, op.User); err != nil {
+				errs[i] = fmt.Errorf("can't index user %s: %w", op.ID, err)
+				continue
+			}
+			batch.SetInternal([]byte(bleveSourcePrefix+op.ID), source)
+			sources[op.ID] = source
+		}
+
+		if op.Version > 0 {
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, uint64(op.Version))
+			batch.SetInternal([]byte(bleveVersionPrefix+op.ID), v)
+			versions[op.ID] = op.Version
+		}
+	}
+
+	if err := s.index.Batch(batch); err != nil {
+		return nil, Temporary(fmt.Errorf("can't apply batch: %w", err))
+	}
+
+	return errs, nil
+}
+
+// version returns the version of the user written most recently, 0 when unknown.
+func (s *BleveSink) version(id string, pending map[string]int64) (int64, error) {
+	if v, ok := pending[id]; ok {
+		return v, nil
+	}
+
+	v, err := s.index.GetInternal([]byte(bleveVersionPrefix + id))
+	if err != nil {
+		return 0, fmt.Errorf("can't read version of user %s: %w", id, err)
+	}
+	if len(v) != 8 {
+		return 0, nil
+	}
+
+	return int64(binary.BigEndian.Uint64(v)), nil
+}
+
+// merge applies partial update to the stored user. Missing user is created from the update.
+func (s *BleveSink) merge(op Operation, pending map[string][]byte) (*models.User, []byte, error) {
+	source, ok := pending[op.ID]
+	if !ok {
+		var err error
+		if source, err = s.index.GetInternal([]byte(bleveSourcePrefix + op.ID)); err != nil {
+			return nil, nil, Temporary(fmt.Errorf("can't read user %s: %w", op.ID, err))
+		}
+	}
+
+	fields := make(map[string]interface{})
+	if len(source) > 0 {
+		if err := json.Unmarshal(source, &fields); err != nil {
+			return nil, nil, fmt.Errorf("can't decode stored user %s: %w", op.ID, err)
+		}
+	}
+	for k, v := range op.Partial {
+		fields[k] = v
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't encode user %s: %w", op.ID, err)
+	}
+
+	var user models.User
+	if err := json.Unmarshal(merged, &user); err != nil {
+		return nil, nil, fmt.Errorf("can't decode updated user %s: %w", op.ID, err)
+	}
+
+	return &user, merged, nil
+}
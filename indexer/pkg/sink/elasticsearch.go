@@ -0,0 +1,158 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// ElasticsearchSink writes users with bulk requests to the index or the alias.
+type ElasticsearchSink struct {
+	name   string
+	client *elastic.Client
+	index  string
+	routed bool
+}
+
+// NewElasticsearch creates sink writing to given index of the Elasticsearch cluster
+// or to the index of the operation when it's set.
+func NewElasticsearch(client *elastic.Client, index string) *ElasticsearchSink {
+	return &ElasticsearchSink{name: Elasticsearch, client: client, index: index, routed: true}
+}
+
+// NewOpenSearch creates sink writing to given index of the OpenSearch cluster.
+// OpenSearch keeps the bulk API of Elasticsearch 7, so the same client is used.
+func NewOpenSearch(client *elastic.Client, index string) *ElasticsearchSink {
+	return &ElasticsearchSink{name: OpenSearch, client: client, index: index}
+}
+
+// Name returns name of the sink.
+func (s *ElasticsearchSink) Name() string {
+	return s.name
+}
+
+// Index returns the index written by the sink.
+func (s *ElasticsearchSink) Index() string {
+	return s.index
+}
+
+// Write indexes or updates users in a single bulk.
+func (s *ElasticsearchSink) Write(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.bulk(ctx, ops)
+}
+
+// Delete removes users in a single bulk.
+func (s *ElasticsearchSink) Delete(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.bulk(ctx, ops)
+}
+
+// Flush does nothing - bulk requests are applied once they are acknowledged.
+func (s *ElasticsearchSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Health verifies that the cluster is not red.
+func (s *ElasticsearchSink) Health(ctx context.Context) error {
+	return esclient.Health(ctx, s.client)
+}
+
+// Close stops the client.
+func (s *ElasticsearchSink) Close() error {
+	s.client.Stop()
+	return nil
+}
+
+func (s *ElasticsearchSink) bulk(ctx context.Context, ops []Operation) ([]error, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	bulk := s.client.Bulk()
+	for _, op := range ops {
+		index := s.index
+		if s.routed && op.Index != "" {
+			index = op.Index
+		}
+		bulk.Add(BulkRequest(index, op))
+	}
+
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't execute bulk: %w", err)
+	}
+	if len(resp.Items) != len(ops) {
+		return nil, fmt.Errorf("got %d items in response for %d requests", len(resp.Items), len(ops))
+	}
+
+	errs := make([]error, len(ops))
+	for i, item := range resp.Items {
+		errs[i] = ItemError(ops[i], BulkItem(item))
+	}
+
+	return errs, nil
+}
+
+// BulkRequest translates the operation into the bulk action on given index.
+// Partial updates are not versioned, as Elasticsearch doesn't support external versions for them.
+func BulkRequest(index string, op Operation) elastic.BulkableRequest {
+	switch op.Op {
+	case OpDelete:
+		req := elastic.NewBulkDeleteRequest().Index(index).Id(op.ID)
+		if op.Version > 0 {
+			req.Version(op.Version).VersionType("external_gte")
+		}
+		return req
+	case OpUpdate:
+		return elastic.NewBulkUpdateRequest().Index(index).Id(op.ID).Doc(op.Partial).DocAsUpsert(true).RetryOnConflict(3)
+	default:
+		req := elastic.NewBulkIndexRequest().Index(index).Type("_doc").Id(op.ID).Doc(op.User)
+		if op.Version > 0 {
+			req.Version(op.Version).VersionType("external_gte")
+		}
+		return req
+	}
+}
+
+// ItemError returns the outcome of the bulk action. Deleting the missing user is not an error,
+// rejections with 429 or 5xx are temporary.
+func ItemError(op Operation, res *elastic.BulkResponseItem) error {
+	switch {
+	case res == nil:
+		return Temporary(errors.New("missing bulk response item"))
+	case res.Status >= 200 && res.Status <= 299:
+		return nil
+	case op.Op == OpDelete && res.Status == http.StatusNotFound:
+		return nil
+	case op.Version > 0 && res.Status == http.StatusConflict:
+		return ErrConflict
+	}
+
+	reason := fmt.Errorf("status %d", res.Status)
+	if res.Error != nil {
+		reason = fmt.Errorf("%s: %s", res.Error.Type, res.Error.Reason)
+	}
+
+	if Retryable(res.Status) {
+		return Temporary(reason)
+	}
+
+	return reason
+}
+
+// Retryable says if the bulk item was rejected due to the temporary condition.
+func Retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// BulkItem returns the result of the single bulk action.
+func BulkItem(item map[string]*elastic.BulkResponseItem) *elastic.BulkResponseItem {
+	for _, res := range item {
+		return res
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// DefaultArchiveMaxBytes is used when ArchiveMaxBytes is not set in config.
+const DefaultArchiveMaxBytes = 100 << 20
+
+// record is the line of the archive.
+type record struct {
+	Op        string                 `json:"op"`
+	ID        string                 `json:"id"`
+	Index     string                 `json:"index,omitempty"`
+	Version   int64                  `json:"version,omitempty"`
+	User      *models.User           `json:"user,omitempty"`
+	Partial   map[string]interface{} `json:"partial,omitempty"`
+	WrittenAt time.Time              `json:"written_at"`
+}
+
+// FileSink archives operations as NDJSON files in the directory. File is rotated when it reaches the max size.
+// Archive keeps all operations in order, so it can be replayed into any other store. Lines are buffered until Flush.
+type FileSink struct {
+	dir      string
+	maxBytes int64
+	compress bool
+
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	w       *bufio.Writer
+	written int64
+	seq     int
+}
+
+// NewFile creates sink archiving operations in given directory. Files are gzipped when compress is set.
+func NewFile(dir string, maxBytes int64, compress bool) (*FileSink, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultArchiveMaxBytes
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create archive directory: %w", err)
+	}
+
+	return &FileSink{dir: dir, maxBytes: maxBytes, compress: compress}, nil
+}
+
+// Name returns name of the sink.
+func (s *FileSink) Name() string {
+	return File
+}
+
+// Write appends index and update operations to the archive.
+func (s *FileSink) Write(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.append(ops)
+}
+
+// Delete appends delete operations to the archive.
+func (s *FileSink) Delete(ctx context.Context, ops []Operation) ([]error, error) {
+	return s.append(ops)
+}
+
+// Flush writes buffered lines and syncs the file.
+func (s *FileSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// Health checks if the directory is writable.
+func (s *FileSink) Health(ctx context.Context) error {
+	f, err := os.Create(filepath.Join(s.dir, ".health"))
+	if err != nil {
+		return fmt.Errorf("can't write to archive directory: %w", err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
+
+// Close flushes and closes the current file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeFile()
+}
+
+// append encodes operations into the temporary buffer and appends it to the archive at once,
+// so the batch retried after the error is never archived partially. File is rotated only between batches.
+func (s *FileSink) append(ops []Operation) ([]error, error) {
+	now := time.Now().UTC()
+	errs := make([]error, len(ops))
+
+	var batch bytes.Buffer
+	for i, op := range ops {
+		line, err := json.Marshal(record{
+			Op:        op.Op,
+			ID:        op.ID,
+			Index:     op.Index,
+			Version:   op.Version,
+			User:      op.User,
+			Partial:   op.Partial,
+			WrittenAt: now,
+		})
+		if err != nil {
+			errs[i] = fmt.Errorf("can't encode operation on user %s: %w", op.ID, err)
+			continue
+		}
+
+		batch.Write(line)
+		batch.WriteByte('\n')
+	}
+	if batch.Len() == 0 {
+		return errs, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil || s.written >= s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return nil, Temporary(err)
+		}
+	}
+
+	n, err := s.w.Write(batch.Bytes())
+	s.written += int64(n)
+	if err != nil {
+		// writer keeps failing after the error, so the batch is retried in the new file
+		name := s.file.Name()
+		s.closeFile()
+		return nil, Temporary(fmt.Errorf("can't write to %s: %w", name, err))
+	}
+
+	return errs, nil
+}
+
+// rotate closes the current file and opens the new one named after the time and the sequence number.
+func (s *FileSink) rotate() error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+
+	s.seq++
+	name := fmt.Sprintf("users-%s-%06d.ndjson", time.Now().UTC().Format("20060102T150405"), s.seq)
+	if s.compress {
+		name += ".gz"
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("can't create archive file: %w", err)
+	}
+
+	var w io.Writer = f
+	s.gz = nil
+	if s.compress {
+		s.gz = gzip.NewWriter(f)
+		w = s.gz
+	}
+	s.file, s.w, s.written = f, bufio.NewWriter(w), 0
+
+	return nil
+}
+
+func (s *FileSink) flush() error {
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("can't write to %s: %w", s.file.Name(), err)
+	}
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return fmt.Errorf("can't write to %s: %w", s.file.Name(), err)
+		}
+	}
+
+	return s.file.Sync()
+}
+
+func (s *FileSink) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.flush()
+	if s.gz != nil {
+		if e := s.gz.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := s.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	s.file, s.gz, s.w = nil, nil, nil
+
+	return err
+}
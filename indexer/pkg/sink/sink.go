@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// Names of the available sinks.
+const (
+	Elasticsearch = "elasticsearch"
+	OpenSearch    = "opensearch"
+	File          = "file"
+	Bleve         = "bleve"
+)
+
+// Operations on the user.
+const (
+	OpIndex  = "index"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// ErrConflict is returned for the operation skipped because the newer version of the user is already written.
+var ErrConflict = errors.New("newer version already written")
+
+// Operation is the change of the user written to sinks. Index overrides the index of the Elasticsearch sink,
+// other sinks keep all users together.
+type Operation struct {
+	Op      string
+	ID      string
+	Index   string
+	User    *models.User
+	Partial map[string]interface{}
+	Version int64
+}
+
+// Sink is the store which users are written to.
+type Sink interface {
+	// Name returns name of the sink used in logs and metrics.
+	Name() string
+	// Write indexes or partially updates users. Returned errors correspond to the operations -
+	// nil when the operation is applied. Error of the whole batch means that nothing was written.
+	Write(ctx context.Context, ops []Operation) ([]error, error)
+	// Delete removes users. Deleting the missing user is not an error.
+	Delete(ctx context.Context, ops []Operation) ([]error, error)
+	// Flush makes written operations durable or visible.
+	Flush(ctx context.Context) error
+	// Health checks if the sink accepts writes.
+	Health(ctx context.Context) error
+	// Close releases resources of the sink.
+	Close() error
+}
+
+// temporaryError is the error after which the operation should be retried.
+type temporaryError struct {
+	err error
+}
+
+func (e temporaryError) Error() string {
+	return e.err.Error()
+}
+
+func (e temporaryError) Unwrap() error {
+	return e.err
+}
+
+// Temporary marks the error as temporary.
+func Temporary(err error) error {
+	return temporaryError{err: err}
+}
+
+// IsTemporary says if the operation failed due to the temporary condition and can be retried.
+func IsTemporary(err error) bool {
+	var t temporaryError
+	return errors.As(err, &t)
+}
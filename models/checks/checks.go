@@ -0,0 +1,202 @@
+// Package checks runs probes of dependencies which decide about readiness of the feeder API, indexer and web API.
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status describes the state of the dependency.
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+const (
+	// DefaultTimeout is the time after which probe is considered as failed.
+	DefaultTimeout = 2 * time.Second
+	// DefaultCacheTTL is the time for which probe result is reused.
+	DefaultCacheTTL = 5 * time.Second
+)
+
+// Probe checks if the dependency works properly.
+type Probe func(ctx context.Context) error
+
+// Result holds the outcome of the single probe.
+type Result struct {
+	Name      string    `json:"name"`
+	Status    Status    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Report holds the outcome of all registered probes.
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	mu     sync.Mutex
+	name   string
+	probe  Probe
+	result Result
+}
+
+// Checker runs registered probes with timeout and caches their results.
+type Checker struct {
+	mu       sync.RWMutex
+	checks   []*check
+	timeout  time.Duration
+	cacheTTL time.Duration
+}
+
+// New creates new Checker.
+func New(timeout, cacheTTL time.Duration) *Checker {
+	return &Checker{timeout: timeout, cacheTTL: cacheTTL}
+}
+
+// Register adds new probe under given name.
+func (c *Checker) Register(name string, probe Probe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, &check{name: name, probe: probe})
+}
+
+// Run runs all probes in parallel and returns the report. Report is up only when all probes are up.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	wg := &sync.WaitGroup{}
+	for i, ch := range checks {
+		wg.Add(1)
+		go func(i int, ch *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+
+	return report
+}
+
+func (c *Checker) run(parent context.Context, ch *check) Result {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if !ch.result.CheckedAt.IsZero() && time.Since(ch.result.CheckedAt) < c.cacheTTL {
+		return ch.result
+	}
+
+	ctx, cancel := context.WithTimeout(parent, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- ch.probe(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New("probe timed out")
+	}
+
+	result := Result{
+		Name:      ch.name,
+		Status:    StatusUp,
+		Duration:  time.Since(start).String(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	// probe interrupted by the caller, e.g. the closed request, says nothing about the dependency
+	if parent.Err() != nil {
+		result.Error = parent.Err().Error()
+		return result
+	}
+	ch.result = result
+
+	return result
+}
+
+// Blocking turns the function which doesn't accept the context, e.g. the call of the Kafka client, into the probe
+// which returns once the context is done. Only one call runs at a time - probes started while it's running wait
+// for its result, so calls hanging longer than the timeout don't pile up.
+func Blocking(fn func() error) Probe {
+	type call struct {
+		done chan struct{}
+		err  error
+	}
+
+	var (
+		mu      sync.Mutex
+		running *call
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		c := running
+		if c == nil {
+			c = &call{done: make(chan struct{})}
+			running = c
+			go func() {
+				c.err = fn()
+
+				mu.Lock()
+				running = nil
+				mu.Unlock()
+				close(c.done)
+			}()
+		}
+		mu.Unlock()
+
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ServeHTTP runs probes and writes the report. Status is 503 Service Unavailable when any probe is down.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	d, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if report.Status != StatusUp {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(d)
+}
@@ -0,0 +1,216 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckerRun(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+	ignoring := func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		probes map[string]Probe
+		status Status
+		errors map[string]string
+	}{
+		{
+			name:   "no probes",
+			status: StatusUp,
+		},
+		{
+			name:   "all up",
+			probes: map[string]Probe{"kafka": up, "elastic": up},
+			status: StatusUp,
+		},
+		{
+			name:   "one down",
+			probes: map[string]Probe{"kafka": up, "elastic": down},
+			status: StatusDown,
+			errors: map[string]string{"elastic": "connection refused"},
+		},
+		{
+			name:   "probe cancelled by the timeout",
+			probes: map[string]Probe{"kafka": hanging},
+			status: StatusDown,
+			errors: map[string]string{"kafka": "probe timed out"},
+		},
+		{
+			name:   "probe ignoring the context",
+			probes: map[string]Probe{"kafka": up, "elastic": ignoring},
+			status: StatusDown,
+			errors: map[string]string{"elastic": "probe timed out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(50*time.Millisecond, time.Minute)
+			for name, probe := range tt.probes {
+				c.Register(name, probe)
+			}
+
+			start := time.Now()
+			report := c.Run(context.Background())
+			if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+				t.Errorf("run took %s despite the timeout", elapsed)
+			}
+
+			if report.Status != tt.status {
+				t.Errorf("status: got %s, want %s", report.Status, tt.status)
+			}
+			if len(report.Checks) != len(tt.probes) {
+				t.Fatalf("got %d results, want %d", len(report.Checks), len(tt.probes))
+			}
+			for _, r := range report.Checks {
+				if r.Error != tt.errors[r.Name] {
+					t.Errorf("error of %s: got %q, want %q", r.Name, r.Error, tt.errors[r.Name])
+				}
+				if (r.Error == "") != (r.Status == StatusUp) {
+					t.Errorf("status of %s is %s with error %q", r.Name, r.Status, r.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckerCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		cacheTTL time.Duration
+		wait     time.Duration
+		calls    int32
+	}{
+		{name: "cached", cacheTTL: time.Minute, calls: 1},
+		{name: "expired", cacheTTL: 10 * time.Millisecond, wait: 20 * time.Millisecond, calls: 3},
+		{name: "not cached", cacheTTL: 0, calls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			failing := int32(1)
+			c := New(time.Second, tt.cacheTTL)
+			c.Register("elastic", func(ctx context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				if atomic.LoadInt32(&failing) == 1 {
+					return errors.New("unavailable")
+				}
+				return nil
+			})
+
+			first := c.Run(context.Background())
+			atomic.StoreInt32(&failing, 0)
+			var last Report
+			for i := 0; i < 2; i++ {
+				time.Sleep(tt.wait)
+				last = c.Run(context.Background())
+			}
+
+			if n := atomic.LoadInt32(&calls); n != tt.calls {
+				t.Errorf("probe called %d times, want %d", n, tt.calls)
+			}
+			if first.Status != StatusDown {
+				t.Errorf("first status: got %s", first.Status)
+			}
+			// the cached failure is reported until it expires
+			cached := tt.calls == 1
+			if cached && last.Checks[0].CheckedAt != first.Checks[0].CheckedAt {
+				t.Errorf("cached result is not reused: %+v", last)
+			}
+			if !cached && last.Status != StatusUp {
+				t.Errorf("last status: got %s, want %s", last.Status, StatusUp)
+			}
+		})
+	}
+}
+
+func TestCheckerServeHTTP(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{name: "up", code: http.StatusOK},
+		{name: "down", err: errors.New("unavailable"), code: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(time.Second, 0)
+			c.Register("kafka", func(ctx context.Context) error { return tt.err })
+
+			w := httptest.NewRecorder()
+			c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+			if w.Code != tt.code {
+				t.Errorf("code: got %d, want %d", w.Code, tt.code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("content type: got %q", ct)
+			}
+		})
+	}
+}
+
+func TestCheckerNotCachedWhenCancelled(t *testing.T) {
+	var calls int32
+	c := New(time.Second, time.Minute)
+	c.Register("kafka", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := c.Run(ctx); report.Status != StatusDown {
+		t.Errorf("status: got %s, want %s", report.Status, StatusDown)
+	}
+
+	c.Run(context.Background())
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("probe called %d times, want 2", n)
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	probe := Blocking(func() error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return errors.New("unavailable")
+	})
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := probe(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("called %d times while the call was running, want 1", n)
+	}
+
+	close(release)
+	if err := probe(context.Background()); err == nil || err.Error() != "unavailable" {
+		t.Errorf("got %v, want the error of the call", err)
+	}
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSVFields is the number of fields of the user in pipe separated files.
+const CSVFields = 12
+
+// ParseUsersCSV reads pipe separated users and calls fn with each user or the error of its record.
+// Records are numbered by the line of the file they start at, counted from 1. Reading stops when fn
+// returns an error or the file can't be read.
+func ParseUsersCSV(reader io.Reader, fn func(line int, user User, err error) error) error {
+	lines := &lineReader{r: bufio.NewReader(reader)}
+	r := csv.NewReader(lines)
+	r.Comma = '|'
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		var (
+			user User
+			line int
+		)
+		if err == nil {
+			// the record ends at the last line read, quoted fields may span more lines
+			line = lines.n
+			for _, field := range record {
+				line -= strings.Count(field, "\n")
+			}
+			user, err = ParseCSVUser(record)
+		} else if perr, ok := err.(*csv.ParseError); ok {
+			line = perr.StartLine
+		} else {
+			return err
+		}
+
+		if err := fn(line, user, err); err != nil {
+			return err
+		}
+	}
+}
+
+// lineReader returns at most one line of the file from each Read, so the number of lines read
+// is known after each record read by the CSV reader.
+type lineReader struct {
+	r       *bufio.Reader
+	pending []byte
+	n       int
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		line, err := l.r.ReadSlice('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		if line[len(line)-1] == '\n' || err == io.EOF {
+			l.n++
+		}
+		l.pending = line
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+
+	return n, nil
+}
+
+// ParseCSVUser converts fields of the record into the user.
+func ParseCSVUser(line []string) (User, error) {
+	u := User{}
+	if len(line) != CSVFields {
+		return u, fmt.Errorf("wrong number of parsed fields: %d", len(line))
+	}
+
+	var err error
+	u.Pnum, err = strconv.ParseInt(line[0], 10, 64)
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize pnum. Val: %s", line[0])
+	}
+
+	long, err := strconv.ParseFloat(line[1], 64)
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize longitude. Val: %s", line[1])
+	}
+
+	lat, err := strconv.ParseFloat(line[2], 64)
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize latitude. Val: %s", line[2])
+	}
+
+	u.Location = &Location{Longitude: long, Latitude: lat}
+	u.Email = &line[3]
+
+	weight, err := strconv.Atoi(line[4])
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize weight. Val: %s", line[4])
+	}
+	u.Weight = &weight
+
+	height, err := strconv.Atoi(line[5])
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize height. Val: %s", line[5])
+	}
+	u.Height = &height
+
+	u.Nickname = &line[6]
+	u.Country, err = strconv.Atoi(line[7])
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize country. Val: %s", line[7])
+	}
+	u.City = &line[8]
+	u.Caption = &line[9]
+
+	gender, err := strconv.Atoi(line[10])
+	if err != nil {
+		return u, fmt.Errorf("can't deserialize gender. Val: %s", line[10])
+	}
+	u.Gender = &gender
+	u.Dob = &line[11]
+
+	return u, nil
+}
@@ -0,0 +1,141 @@
+// Package esclient creates clients of Elasticsearch (and OpenSearch) clusters shared by the indexer and web API.
+package esclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mateuszdyminski/am-pipeline/models/secret"
+	"github.com/mateuszdyminski/am-pipeline/models/tlsconfig"
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// Config of the cluster connection. Only one of basic auth, API key and bearer token can be used.
+// Username, Password, APIKey and BearerToken may point to the environment variable ("env:NAME")
+// or to the file ("file:/path") holding the secret.
+type Config struct {
+	URLs []string
+
+	Username    string
+	Password    string
+	APIKey      string
+	BearerToken string
+
+	TLS tlsconfig.Config
+}
+
+// New connects to the cluster. Sniffing is disabled, as nodes are usually behind the service or the load balancer.
+func New(cfg Config, options ...elastic.ClientOptionFunc) (*elastic.Client, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no URLs of the cluster")
+	}
+
+	httpClient, err := cfg.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []elastic.ClientOptionFunc{
+		elastic.SetURL(cfg.URLs...),
+		elastic.SetHttpClient(httpClient),
+		elastic.SetSniff(false),
+	}
+
+	client, err := elastic.NewClient(append(opts, options...)...)
+	if err != nil {
+		return nil, fmt.Errorf("can't create elastic client: %w", err)
+	}
+
+	return client, nil
+}
+
+// Health verifies that the cluster is not red.
+func Health(ctx context.Context, client *elastic.Client) error {
+	health, err := client.ClusterHealth().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get cluster health: %w", err)
+	}
+
+	if health.Status == "red" {
+		return errors.New("cluster status is red")
+	}
+
+	return nil
+}
+
+// HTTPClient returns HTTP client with TLS settings which authorizes requests with configured credentials.
+func (cfg Config) HTTPClient() (*http.Client, error) {
+	tlsCfg, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	auth, err := cfg.authorization()
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		return &http.Client{Transport: transport}, nil
+	}
+
+	return &http.Client{Transport: &authTransport{next: transport, auth: auth}}, nil
+}
+
+// authorization returns the function setting credentials on the request, nil when there are no credentials.
+func (cfg Config) authorization() (func(*http.Request), error) {
+	username, err := secret.Resolve(cfg.Username)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve username: %w", err)
+	}
+	password, err := secret.Resolve(cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve password: %w", err)
+	}
+	apiKey, err := secret.Resolve(cfg.APIKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve API key: %w", err)
+	}
+	token, err := secret.Resolve(cfg.BearerToken)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve bearer token: %w", err)
+	}
+
+	var auths []func(*http.Request)
+	if username != "" {
+		auths = append(auths, func(r *http.Request) { r.SetBasicAuth(username, password) })
+	}
+	if apiKey != "" {
+		auths = append(auths, func(r *http.Request) { r.Header.Set("Authorization", "ApiKey "+apiKey) })
+	}
+	if token != "" {
+		auths = append(auths, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) })
+	}
+
+	switch len(auths) {
+	case 0:
+		return nil, nil
+	case 1:
+		return auths[0], nil
+	default:
+		return nil, errors.New("only one of username, API key and bearer token can be set")
+	}
+}
+
+// authTransport sets credentials on each request.
+type authTransport struct {
+	next http.RoundTripper
+	auth func(*http.Request)
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// round trippers must not modify the request
+	req = req.Clone(req.Context())
+	t.auth(req)
+
+	return t.next.RoundTrip(req)
+}
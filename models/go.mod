@@ -1,3 +1,9 @@
 module github.com/mateuszdyminski/am-pipeline/models
 
 go 1.13
+
+require (
+	github.com/Shopify/sarama v1.23.1
+	github.com/olivere/elastic/v7 v7.0.6
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+)
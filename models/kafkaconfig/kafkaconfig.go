@@ -0,0 +1,109 @@
+// Package kafkaconfig sets up TLS and SASL of Kafka clients shared by the feeder, feeder API and indexer.
+package kafkaconfig
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/models/secret"
+	"github.com/mateuszdyminski/am-pipeline/models/tlsconfig"
+	"github.com/xdg/scram"
+)
+
+// SASL mechanisms supported by the clients.
+const (
+	MechanismPlain       = "PLAIN"
+	MechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	MechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// Config of the connection security. Brokers are authenticated with TLS when any of TLS settings is set
+// and clients with SASL when Mechanism is set.
+type Config struct {
+	TLS  tlsconfig.Config
+	SASL SASL
+}
+
+// SASL authentication. Username and Password may point to the environment variable ("env:NAME")
+// or to the file ("file:/path") holding the secret.
+type SASL struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// Apply sets TLS and SASL of the sarama config.
+func (c Config) Apply(cfg *sarama.Config) error {
+	if c.TLS.IsSet() {
+		tlsCfg, err := c.TLS.Load()
+		if err != nil {
+			return err
+		}
+
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsCfg
+	}
+
+	if c.SASL.Mechanism == "" {
+		return nil
+	}
+
+	username, err := secret.Resolve(c.SASL.Username)
+	if err != nil {
+		return fmt.Errorf("can't resolve SASL username: %w", err)
+	}
+	password, err := secret.Resolve(c.SASL.Password)
+	if err != nil {
+		return fmt.Errorf("can't resolve SASL password: %w", err)
+	}
+	if username == "" {
+		return fmt.Errorf("username is required by SASL %s", c.SASL.Mechanism)
+	}
+
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = username
+	cfg.Net.SASL.Password = password
+
+	switch strings.ToUpper(c.SASL.Mechanism) {
+	case MechanismPlain:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case MechanismSCRAMSHA256:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: sha256.New} }
+	case MechanismSCRAMSHA512:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: sha512.New} }
+	default:
+		return fmt.Errorf("unknown SASL mechanism %q", c.SASL.Mechanism)
+	}
+
+	return nil
+}
+
+// scramClient runs the SCRAM conversation with the broker.
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return fmt.Errorf("can't create SCRAM client: %w", err)
+	}
+
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
@@ -14,6 +14,21 @@ type User struct {
 	Location *Location `json:"location,omitempty" gorethink:"location"`
 	Gender   *int      `json:"gender,omitempty" gorethink:"gender"`
 	Score    *float64  `json:"score,omitempty"`
+
+	// Fields derived by the indexer
+	Age         *int     `json:"age,omitempty"`
+	AgeBucket   *string  `json:"age_bucket,omitempty"`
+	BMI         *float64 `json:"bmi,omitempty"`
+	CountryName *string  `json:"country_name,omitempty"`
+	CountryISO  *string  `json:"country_iso,omitempty"`
+	Geohash     *string  `json:"geohash,omitempty"`
+	EmailDomain *string  `json:"email_domain,omitempty"`
+
+	// Fields derived by reverse geocoding of the location
+	GeoCity          *string `json:"geo_city,omitempty"`
+	GeoRegion        *string `json:"geo_region,omitempty"`
+	GeoCountry       *string `json:"geo_country,omitempty"`
+	LocationMismatch *bool   `json:"location_mismatch,omitempty"`
 }
 
 func ParseUser(value interface{}) (User, error) {
@@ -96,7 +111,7 @@ const ElasticMappingString = `
             },
             "mappings" : {
                 "properties" : {
-                    "id" : { "type" : "text" },
+                    "id" : { "type" : "long" },
                     "email" : { "type" : "text" },
                     "dob" : { "type" : "date" },
                     "weight" : { "type" : "integer" },
@@ -116,7 +131,18 @@ const ElasticMappingString = `
                     "city" : { "type" : "text" },
                     "caption" : { "type" : "text" },
                     "location" : { "type" : "geo_point" },
-                    "gender" : { "type" : "integer" }
+                    "gender" : { "type" : "integer" },
+                    "age" : { "type" : "integer" },
+                    "age_bucket" : { "type" : "keyword" },
+                    "bmi" : { "type" : "float" },
+                    "country_name" : { "type" : "keyword" },
+                    "country_iso" : { "type" : "keyword" },
+                    "geohash" : { "type" : "keyword" },
+                    "email_domain" : { "type" : "keyword" },
+                    "geo_city" : { "type" : "keyword" },
+                    "geo_region" : { "type" : "keyword" },
+                    "geo_country" : { "type" : "keyword" },
+                    "location_mismatch" : { "type" : "boolean" }
                 }
             }
         }`
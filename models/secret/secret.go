@@ -0,0 +1,39 @@
+// Package secret resolves credentials kept out of config files.
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Prefixes of the values which point to the place of the secret.
+const (
+	// EnvPrefix reads the secret from the environment variable, e.g. "env:ELASTIC_PASSWORD".
+	EnvPrefix = "env:"
+	// FilePrefix reads the secret from the file, e.g. "file:/etc/elastic/password". Trailing new line is dropped.
+	FilePrefix = "file:"
+)
+
+// Resolve returns the secret the value points to. Values without known prefix are returned as they are.
+func Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, EnvPrefix):
+		name := strings.TrimPrefix(value, EnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, FilePrefix):
+		path := strings.TrimPrefix(value, FilePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("can't read secret: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
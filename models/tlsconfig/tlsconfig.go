@@ -0,0 +1,61 @@
+// Package tlsconfig builds TLS configuration of clients connecting to Elasticsearch and Kafka.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config of TLS. Server certificates are verified with system roots extended by CAFile.
+// Client certificate is sent when CertFile and KeyFile are set.
+type Config struct {
+	Enabled  bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// Insecure skips verification of server certificates. Use only for development.
+	Insecure bool
+}
+
+// IsSet says if TLS is requested by any of the settings.
+func (c Config) IsSet() bool {
+	return c.Enabled || c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.Insecure
+}
+
+// Load returns TLS configuration with loaded certificates.
+func (c Config) Load() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: c.Insecure}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("both CertFile and KeyFile are required for client certificate")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
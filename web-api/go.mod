@@ -12,3 +12,5 @@ require (
 	github.com/rs/zerolog v1.15.0
 	github.com/sirupsen/logrus v1.4.2
 )
+
+replace github.com/mateuszdyminski/am-pipeline/models => ../models
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/analyzer"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/config"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/server"
@@ -36,5 +37,8 @@ func main() {
 		log.Fatal("can't create analyzer", err)
 	}
 
-	server.ListenAndServe(ctx, cfg, analyzer)
+	checker := checks.New(checks.DefaultTimeout, checks.DefaultCacheTTL)
+	checker.Register("elasticsearch", analyzer.CheckElastic)
+
+	server.ListenAndServe(ctx, cfg, analyzer, checker)
 }
@@ -2,14 +2,13 @@ package analyzer
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"strconv"
 
 	"github.com/mateuszdyminski/am-pipeline/models"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/config"
 
 	elastic "github.com/olivere/elastic/v7"
@@ -17,9 +16,13 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultIndexAlias is used when IndexAlias is not set in config.
+const DefaultIndexAlias = "users"
+
 // Analyzer allows to Analyzer data taken from ElasticSearch
 type Analyzer struct {
 	cfg          *config.Config
+	index        string
 	esClient     *elastic.Client
 	esRequest    *prometheus.CounterVec
 	esRequestErr *prometheus.CounterVec
@@ -27,22 +30,10 @@ type Analyzer struct {
 
 // NewAnalyzer creates new Analyzer.
 func NewAnalyzer(cfg *config.Config) (*Analyzer, error) {
-	// elasticsearch client initialization
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	httpClient := &http.Client{Transport: tr}
-
 	// connect to the cluster
-	client, err := elastic.NewClient(
-		elastic.SetURL(cfg.Elastics...),
-		elastic.SetBasicAuth(cfg.ElasticUser, cfg.ElasticPassword),
-		elastic.SetHttpClient(httpClient),
-		elastic.SetSniff(false),
-		elastic.SetScheme("https"),
-	)
+	client, err := esclient.New(cfg.Elastic)
 	if err != nil {
-		return nil, fmt.Errorf("can't create elastic client. err: %v", err)
+		return nil, err
 	}
 
 	esRequest := prometheus.NewCounterVec(
@@ -68,8 +59,14 @@ func NewAnalyzer(cfg *config.Config) (*Analyzer, error) {
 	prometheus.Register(esRequest)
 	prometheus.Register(esRequestErr)
 
+	index := cfg.IndexAlias
+	if index == "" {
+		index = DefaultIndexAlias
+	}
+
 	analyzer := &Analyzer{
 		cfg:          cfg,
+		index:        index,
 		esClient:     client,
 		esRequest:    esRequest,
 		esRequestErr: esRequestErr,
@@ -122,15 +119,15 @@ func (a *Analyzer) Search(
 	}
 
 	searchResult, err := a.esClient.Search().
-		Index("users").
+		Index(a.index).
 		Query(elasticQuery).
 		From(skipInt).Size(sizeInt).
 		Do(context.Background())
 	if err != nil {
-		a.esRequestErr.WithLabelValues("users").Inc()
+		a.esRequestErr.WithLabelValues(a.index).Inc()
 		return nil, fmt.Errorf("can't search for users. err: %w", err)
 	}
-	a.esRequest.WithLabelValues("users").Inc()
+	a.esRequest.WithLabelValues(a.index).Inc()
 
 	response := UsersResponse{}
 
@@ -167,16 +164,16 @@ func (a *Analyzer) Nicks(nick string) ([]string, error) {
 	// Search with a term query
 	matchQuery := elastic.NewMatchQuery("nickname.autocomplete", nick)
 	searchResult, err := a.esClient.Search().
-		Index("users").
+		Index(a.index).
 		Query(matchQuery).
 		From(0).Size(20).
 		Do(context.Background())
 	if err != nil {
-		a.esRequestErr.WithLabelValues("users").Inc()
+		a.esRequestErr.WithLabelValues(a.index).Inc()
 		return nil, fmt.Errorf("can't search for autocomplete. err: %w", err)
 	}
 
-	a.esRequest.WithLabelValues("users").Inc()
+	a.esRequest.WithLabelValues(a.index).Inc()
 
 	nicks := make([]string, 0)
 
@@ -206,14 +203,14 @@ func (a *Analyzer) Aggregations(field string) ([]Bucket, error) {
 	termsAgg := elastic.NewTermsAggregation().Field(field)
 
 	searchResult, err := a.esClient.Search().
-		Index("users").
+		Index(a.index).
 		Aggregation("top_field", termsAgg).
 		Do(context.Background())
 	if err != nil {
-		a.esRequestErr.WithLabelValues("users").Inc()
+		a.esRequestErr.WithLabelValues(a.index).Inc()
 		return nil, fmt.Errorf("can't search for autocomplete. err: %w", err)
 	}
-	a.esRequest.WithLabelValues("users").Inc()
+	a.esRequest.WithLabelValues(a.index).Inc()
 
 	buckets := make([]Bucket, 0)
 	if searchResult.Aggregations != nil {
@@ -233,6 +230,11 @@ func (a *Analyzer) Aggregations(field string) ([]Bucket, error) {
 	return buckets, nil
 }
 
+// CheckElastic verifies that Elasticsearch cluster is not red.
+func (a *Analyzer) CheckElastic(ctx context.Context) error {
+	return esclient.Health(ctx, a.esClient)
+}
+
 // UsersResponse holds information about users and total number of hits.
 type UsersResponse struct {
 	Users []models.User `json:"users,omitempty"`
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 
 	"github.com/BurntSushi/toml"
+	"github.com/mateuszdyminski/am-pipeline/models/esclient"
 )
 
 // Config holds configuration of feeder.
@@ -13,13 +14,12 @@ type Config struct {
 	Topic   string
 
 	// HTTP Server config
-	HTTPPort           int
-	EnableCORS         bool
+	HTTPPort   int
+	EnableCORS bool
 
 	// Elastisearch config
-	Elastics        []string
-	ElasticUser     string
-	ElasticPassword string
+	Elastic    esclient.Config
+	IndexAlias string
 }
 
 // LoadConfig loads config from env vars.
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/mateuszdyminski/am-pipeline/models/checks"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/analyzer"
 	"github.com/mateuszdyminski/am-pipeline/web-api/pkg/config"
 
@@ -25,10 +26,11 @@ var (
 type Server struct {
 	mux      *mux.Router
 	analyzer *analyzer.Analyzer
+	checks   *checks.Checker
 }
 
-func NewServer(cfg *config.Config, a *analyzer.Analyzer, options ...func(*Server)) http.Handler {
-	s := &Server{mux: mux.NewRouter(), analyzer: a}
+func NewServer(cfg *config.Config, a *analyzer.Analyzer, checker *checks.Checker, options ...func(*Server)) http.Handler {
+	s := &Server{mux: mux.NewRouter(), analyzer: a, checks: checker}
 
 	for _, f := range options {
 		f(s)
@@ -60,12 +62,12 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func ListenAndServe(cancelCtx context.Context, cfg *config.Config, analyzer *analyzer.Analyzer) {
+func ListenAndServe(cancelCtx context.Context, cfg *config.Config, analyzer *analyzer.Analyzer, checker *checks.Checker) {
 	inst := NewInstrument()
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.HTTPPort),
-		Handler:      inst.Wrap(NewServer(cfg, analyzer)),
+		Handler:      inst.Wrap(NewServer(cfg, analyzer, checker)),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 1 * time.Minute,
 		IdleTimeout:  15 * time.Second,